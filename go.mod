@@ -2,9 +2,9 @@ module github.com/nilsboi/Chirpy
 
 go 1.22.5
 
-require golang.org/x/crypto v0.26.0
-
 require (
-	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.26.0
+	golang.org/x/text v0.17.0
 )
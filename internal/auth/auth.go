@@ -0,0 +1,98 @@
+// Package auth issues and validates the access tokens of the API.
+// Tokens are signed with Ed25519 keys from a Keyring so services that only
+// verify them need nothing but the public JWKS.
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	Issuer   = "chirpy"
+	Audience = "chirpy-api"
+
+	AccessTokenLifetime = time.Hour
+)
+
+var ErrMissingToken = errors.New("missing or malformed authorization header")
+
+// Claims are the claims of an access token
+type Claims struct {
+	jwt.RegisteredClaims
+	// SessionID ties the token to the login it was issued for
+	SessionID string `json:"sid,omitempty"`
+	Role      string `json:"role,omitempty"`
+
+	UserID int `json:"-"`
+}
+
+// IssueAccessToken signs a JWT for the user and session
+func IssueAccessToken(userID int, sessionID string, role string, keys *Keyring, lifetime time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+			Issuer:    Issuer,
+			Audience:  jwt.ClaimStrings{Audience},
+			Subject:   strconv.Itoa(userID),
+		},
+		SessionID: sessionID,
+		Role:      role,
+	}
+
+	return keys.sign(claims)
+}
+
+// ValidateAccessToken checks signature, algorithm, expiry, issuer and
+// audience of a JWT and returns its claims
+func ValidateAccessToken(tokenString string, keys *Keyring) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims.UserID, err = strconv.Atoi(claims.Subject)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// BearerToken extracts the token of an "Authorization: Bearer <token>" header
+func BearerToken(headers http.Header) (string, error) {
+	return authorization(headers, "Bearer")
+}
+
+// APIKey extracts the key of an "Authorization: ApiKey <key>" header
+func APIKey(headers http.Header) (string, error) {
+	return authorization(headers, "ApiKey")
+}
+
+func authorization(headers http.Header, scheme string) (string, error) {
+	prefix, token, found := strings.Cut(headers.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+
+	if !found || !strings.EqualFold(prefix, scheme) || token == "" {
+		return "", ErrMissingToken
+	}
+
+	return token, nil
+}
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// kidLayout names key files so that they sort by creation time
+const kidLayout = "20060102T150405Z"
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// signingKey is an Ed25519 key pair identified by its kid
+type signingKey struct {
+	id      string
+	created time.Time
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// Keyring holds the keys access tokens are signed with. The newest key signs,
+// older keys only verify until every token they signed has expired.
+type Keyring struct {
+	mu   sync.RWMutex
+	dir  string
+	keys []signingKey // oldest first
+	// retain is how long a key verifies after it was replaced
+	retain time.Duration
+}
+
+// JWK is the public half of a signing key as published in the JWKS
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeyring reads every <kid>.pem in dir and creates a first key if there is none.
+// retain must be at least the longest lifetime of an access token.
+func LoadKeyring(dir string, retain time.Duration) (*Keyring, error) {
+	err := os.MkdirAll(dir, 0700)
+
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{dir: dir, retain: retain}
+
+	err = k.Reload()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k.keys) == 0 {
+		_, err = k.Rotate(time.Now().UTC())
+	}
+
+	return k, err
+}
+
+// Reload rereads the key directory so keys rotated by another instance are picked up
+func (k *Keyring) Reload() error {
+	entries, err := os.ReadDir(k.dir)
+
+	if err != nil {
+		return err
+	}
+
+	keys := []signingKey{}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".pem")
+
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		key, err := readKey(filepath.Join(k.dir, entry.Name()), id)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b signingKey) int { return strings.Compare(a.id, b.id) })
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+
+	return nil
+}
+
+func readKey(path string, id string) (signingKey, error) {
+	created, err := time.Parse(kidLayout, id)
+
+	if err != nil {
+		return signingKey{}, errors.New("file name is not a key id")
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return signingKey{}, errors.New("no PKCS #8 private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	private, ok := parsed.(ed25519.PrivateKey)
+
+	if !ok {
+		return signingKey{}, errors.New("not an Ed25519 key")
+	}
+
+	return signingKey{
+		id:      id,
+		created: created,
+		private: private,
+		public:  private.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// Rotate creates a new signing key. Older keys keep verifying until they are pruned.
+func (k *Keyring) Rotate(now time.Time) (string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+
+	if err != nil {
+		return "", err
+	}
+
+	key := signingKey{
+		id:      now.UTC().Format(kidLayout),
+		created: now.UTC().Truncate(time.Second),
+		private: private,
+		public:  public,
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if n := len(k.keys); n > 0 && k.keys[n-1].id >= key.id {
+		return "", errors.New("a key was already created at " + key.id)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	err = os.WriteFile(filepath.Join(k.dir, key.id+".pem"), data, 0600)
+
+	if err != nil {
+		return "", err
+	}
+
+	k.keys = append(k.keys, key)
+
+	return key.id, nil
+}
+
+// RotateIfDue rotates when the signing key is older than every and deletes
+// keys that were replaced more than retain ago
+func (k *Keyring) RotateIfDue(now time.Time, every time.Duration) (string, error) {
+	k.mu.RLock()
+	due := len(k.keys) == 0 || now.Sub(k.keys[len(k.keys)-1].created) >= every
+	k.mu.RUnlock()
+
+	rotated := ""
+
+	if due {
+		var err error
+		rotated, err = k.Rotate(now)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return rotated, k.prune(now)
+}
+
+func (k *Keyring) prune(now time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// a key is retired once its successor has signed for longer than retain
+	for len(k.keys) > 1 && now.Sub(k.keys[1].created) > k.retain {
+		err := os.Remove(filepath.Join(k.dir, k.keys[0].id+".pem"))
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		k.keys = k.keys[1:]
+	}
+
+	return nil
+}
+
+// sign signs claims with the newest key and names it in the kid header
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return "", ErrUnknownKey
+	}
+
+	key := k.keys[len(k.keys)-1]
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = key.id
+
+	return token.SignedString(key.private)
+}
+
+// verificationKey looks up the public key named in the kid header of a token
+func (k *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.id == id {
+			return key.public, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// JWKS returns the public keys that currently verify tokens
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	jwks := JWKS{Keys: []JWK{}}
+
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		jwks.Keys = append(jwks.Keys, JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(k.keys[i].public),
+			KeyID:     k.keys[i].id,
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodEdDSA.Alg(),
+		})
+	}
+
+	return jwks
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var keyEpoch = time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+// testKeyring returns a keyring with one key created at keyEpoch
+func testKeyring(t *testing.T) *Keyring {
+	t.Helper()
+
+	k := &Keyring{dir: t.TempDir(), retain: time.Hour}
+
+	if _, err := k.Rotate(keyEpoch); err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+// jwksIDs lists the kids published in the JWKS, newest first
+func jwksIDs(t *testing.T, k *Keyring) []string {
+	t.Helper()
+
+	ids := []string{}
+
+	for _, jwk := range k.JWKS().Keys {
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+
+		if err != nil || len(x) != 32 || jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.Algorithm != "EdDSA" {
+			t.Errorf("malformed JWK %+v", jwk)
+		}
+
+		ids = append(ids, jwk.KeyID)
+	}
+
+	return ids
+}
+
+func TestKeyringRotation(t *testing.T) {
+	k := testKeyring(t)
+	first := keyEpoch.Format(kidLayout)
+
+	old, err := IssueAccessToken(1, "session", "", k, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rotated, err := k.RotateIfDue(keyEpoch.Add(23*time.Hour), 24*time.Hour); err != nil || rotated != "" {
+		t.Fatalf("RotateIfDue before it's due = %q, %v, want no rotation", rotated, err)
+	}
+
+	rotatedAt := keyEpoch.Add(24 * time.Hour)
+	second, err := k.RotateIfDue(rotatedAt, 24*time.Hour)
+
+	if err != nil || second != rotatedAt.Format(kidLayout) {
+		t.Fatalf("RotateIfDue = %q, %v, want a key created at %s", second, err, rotatedAt)
+	}
+
+	if _, err := ValidateAccessToken(old, k); err != nil {
+		t.Errorf("token of the previous key after the rotation: %v", err)
+	}
+
+	current, err := IssueAccessToken(1, "session", "", k, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := jwksIDs(t, k); !slices.Equal(ids, []string{second, first}) {
+		t.Errorf("JWKS during the overlap = %v, want %v", ids, []string{second, first})
+	}
+
+	// the previous key is kept for exactly retain after its successor took over
+	if _, err := k.RotateIfDue(rotatedAt.Add(time.Hour), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := jwksIDs(t, k); len(ids) != 2 {
+		t.Errorf("JWKS at the end of the overlap = %v, want both keys", ids)
+	}
+
+	if _, err := k.RotateIfDue(rotatedAt.Add(time.Hour+time.Second), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateAccessToken(old, k); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of the pruned key: got %v, want ErrUnknownKey", err)
+	}
+
+	if _, err := ValidateAccessToken(current, k); err != nil {
+		t.Errorf("token of the current key: %v", err)
+	}
+
+	if ids := jwksIDs(t, k); !slices.Equal(ids, []string{second}) {
+		t.Errorf("JWKS after pruning = %v, want %v", ids, []string{second})
+	}
+
+	if _, err := os.Stat(filepath.Join(k.dir, first+".pem")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pruned key file: %v, want it deleted", err)
+	}
+
+	// another instance sharing the directory sees the same keys
+	reloaded, err := LoadKeyring(k.dir, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateAccessToken(current, reloaded); err != nil {
+		t.Errorf("token of the current key after a reload: %v", err)
+	}
+
+	if ids := jwksIDs(t, reloaded); !slices.Equal(ids, []string{second}) {
+		t.Errorf("JWKS after a reload = %v, want %v", ids, []string{second})
+	}
+}
+
+func TestKeyringRejectsForeignKeys(t *testing.T) {
+	k := testKeyring(t)
+
+	// a keyring elsewhere whose key has a kid this one doesn't know
+	other := &Keyring{dir: t.TempDir(), retain: time.Hour}
+
+	if _, err := other.Rotate(keyEpoch.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	unknown, err := IssueAccessToken(1, "session", "", other, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateAccessToken(unknown, k); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown kid: got %v, want ErrUnknownKey", err)
+	}
+
+	// a keyring elsewhere whose key has the same kid but different bytes
+	impostor := testKeyring(t)
+	forged, err := IssueAccessToken(1, "session", "", impostor, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateAccessToken(forged, k); err == nil {
+		t.Error("token signed by another key with a known kid validated")
+	}
+}
+
+func TestRotateRefusesDuplicateKid(t *testing.T) {
+	k := testKeyring(t)
+
+	if _, err := k.Rotate(keyEpoch.Add(time.Millisecond)); err == nil {
+		t.Error("Rotate created a second key in the same second")
+	}
+
+	if ids := jwksIDs(t, k); len(ids) != 1 {
+		t.Errorf("JWKS = %v, want one key", ids)
+	}
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultClientType is used for logins that don't name their client
+const DefaultClientType = "web"
+
+// Lifetimes is the token policy of one type of client
+type Lifetimes struct {
+	// AccessDefault is used when the client doesn't ask for a lifetime
+	AccessDefault time.Duration
+	// AccessMax caps the lifetime a client may ask for
+	AccessMax time.Duration
+	Refresh   time.Duration
+}
+
+// Policy maps client types to their token lifetimes
+type Policy map[string]Lifetimes
+
+var DefaultPolicy = Policy{
+	"web":    {AccessDefault: AccessTokenLifetime, AccessMax: AccessTokenLifetime, Refresh: 60 * 24 * time.Hour},
+	"mobile": {AccessDefault: AccessTokenLifetime, AccessMax: 24 * time.Hour, Refresh: 90 * 24 * time.Hour},
+	"cli":    {AccessDefault: 15 * time.Minute, AccessMax: 12 * time.Hour, Refresh: 30 * 24 * time.Hour},
+}
+
+// lifetimesFile is Lifetimes as written in the policy file, durations use time.ParseDuration
+type lifetimesFile struct {
+	AccessDefault string `json:"access_default"`
+	AccessMax     string `json:"access_max"`
+	Refresh       string `json:"refresh"`
+}
+
+// LoadPolicy reads the token policy from a JSON file. A missing file means the default policy.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Token policy %s not found, using default lifetimes", path)
+		return DefaultPolicy, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	file := map[string]lifetimesFile{}
+
+	err = json.Unmarshal(data, &file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	policy := Policy{}
+
+	for clientType, l := range file {
+		lifetimes := Lifetimes{}
+
+		for _, field := range []struct {
+			name  string
+			value string
+			dst   *time.Duration
+		}{
+			{"access_default", l.AccessDefault, &lifetimes.AccessDefault},
+			{"access_max", l.AccessMax, &lifetimes.AccessMax},
+			{"refresh", l.Refresh, &lifetimes.Refresh},
+		} {
+			*field.dst, err = time.ParseDuration(field.value)
+
+			if err != nil || *field.dst <= 0 {
+				return nil, fmt.Errorf("%s.%s: invalid duration %q", clientType, field.name, field.value)
+			}
+		}
+
+		if lifetimes.AccessDefault > lifetimes.AccessMax {
+			return nil, fmt.Errorf("%s: access_default is longer than access_max", clientType)
+		}
+
+		policy[clientType] = lifetimes
+	}
+
+	if _, ok := policy[DefaultClientType]; !ok {
+		return nil, fmt.Errorf("the policy needs an entry for %q", DefaultClientType)
+	}
+
+	return policy, nil
+}
+
+// For returns the lifetimes of a client type, unknown types get the default one
+func (p Policy) For(clientType string) Lifetimes {
+	if lifetimes, ok := p[clientType]; ok {
+		return lifetimes
+	}
+
+	return p[DefaultClientType]
+}
+
+// ClientType returns clientType if the policy knows it, the default type otherwise
+func (p Policy) ClientType(clientType string) string {
+	if _, ok := p[clientType]; ok {
+		return clientType
+	}
+
+	return DefaultClientType
+}
+
+// MaxAccess is the longest lifetime any access token can have
+func (p Policy) MaxAccess() time.Duration {
+	max := time.Duration(0)
+
+	for _, lifetimes := range p {
+		if lifetimes.AccessMax > max {
+			max = lifetimes.AccessMax
+		}
+	}
+
+	return max
+}
+
+// Access clamps a requested access token lifetime, zero asks for the default
+func (l Lifetimes) Access(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return l.AccessDefault
+	}
+
+	return min(requested, l.AccessMax)
+}
@@ -0,0 +1,26 @@
+package auth
+
+// Roles of a user, each one includes the rights of the roles before it
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the rights of min.
+// Unknown roles, like the empty role of older accounts, are plain users.
+func HasRole(role string, min string) bool {
+	return roleRank[role] >= roleRank[min]
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+// APIKeyPrefix marks personal access tokens so they can't be mistaken for JWTs
+const APIKeyPrefix = "chirpy_pat_"
+
+// Scopes an API key can be granted. Access tokens of a session have all of them.
+const (
+	ScopeChirpsRead  = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+)
+
+var Scopes = []string{ScopeChirpsRead, ScopeChirpsWrite}
+
+// IsAPIKey reports whether a bearer token is an API key rather than an access token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// ValidScope reports whether scope is one an API key can be granted
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
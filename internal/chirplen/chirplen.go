@@ -0,0 +1,158 @@
+// Package chirplen measures chirps the way people read them: in
+// user-perceived characters (grapheme clusters) instead of bytes.
+package chirplen
+
+//go:generate go run gen.go
+
+import (
+	"regexp"
+	"unicode"
+)
+
+// URLWeight is what every link counts for, no matter how long it is
+const URLWeight = 23
+
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// Count returns the weighted length of a chirp body
+func Count(body string) int {
+	length := 0
+	last := 0
+
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		length += Graphemes(body[last:loc[0]]) + URLWeight
+		last = loc[1]
+	}
+
+	return length + Graphemes(body[last:])
+}
+
+// Graphemes counts the grapheme clusters of s. It implements the parts of
+// the Unicode segmentation rules (UAX #29) that matter for chirps: combining
+// marks, emoji modifiers and ZWJ sequences, flags, Hangul syllables and CRLF.
+func Graphemes(s string) int {
+	count := 0
+	state := clusterState{}
+	var prev rune = -1
+
+	for _, r := range s {
+		if prev == -1 || breakBetween(prev, r, state) {
+			count++
+			state = clusterState{}
+		}
+
+		state.advance(r)
+		prev = r
+	}
+
+	return count
+}
+
+const (
+	zwj  = '\u200d'
+	zwnj = '\u200c'
+)
+
+// clusterState is what the rules need to know about the cluster so far
+type clusterState struct {
+	// riCount is the number of regional indicators in a row
+	riCount int
+	// pictographic is set while the cluster ends in a pictograph followed
+	// by nothing but extending runes, the start of an emoji ZWJ sequence
+	pictographic bool
+	// afterZWJ is set when a ZWJ continues such a sequence
+	afterZWJ bool
+}
+
+func (c *clusterState) advance(r rune) {
+	switch {
+	case r == zwj:
+		c.afterZWJ = c.pictographic
+		c.pictographic = false
+	case extend(r):
+		c.afterZWJ = false
+	default:
+		c.pictographic = pictographic(r)
+		c.afterZWJ = false
+	}
+
+	if regionalIndicator(r) {
+		c.riCount++
+	} else {
+		c.riCount = 0
+	}
+}
+
+func breakBetween(prev rune, r rune, state clusterState) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return false
+	case control(prev) || control(r):
+		return true
+	case hangulL(prev) && (hangulL(r) || hangulV(r) || hangulLV(r) || hangulLVT(r)):
+		return false
+	case (hangulV(prev) || hangulLV(prev)) && (hangulV(r) || hangulT(r)):
+		return false
+	case (hangulT(prev) || hangulLVT(prev)) && hangulT(r):
+		return false
+	case extend(r) || r == zwj || unicode.Is(unicode.Mc, r):
+		return false
+	case prev == zwj && state.afterZWJ && pictographic(r):
+		return false
+	case regionalIndicator(prev) && regionalIndicator(r):
+		// flags are pairs of regional indicators
+		return state.riCount%2 == 0
+	}
+
+	return true
+}
+
+// control reports the runes a cluster always ends before and after: line
+// breaks, control characters and invisible formatting characters
+func control(r rune) bool {
+	if r == zwj || r == zwnj || extend(r) {
+		return false
+	}
+
+	return unicode.In(r, unicode.Cc, unicode.Cf, unicode.Zl, unicode.Zp)
+}
+
+func extend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me) || r == zwnj ||
+		(r >= 0xFE00 && r <= 0xFE0F) || // variation selectors
+		(r >= 0x1F3FB && r <= 0x1F3FF) || // skin tone modifiers
+		(r >= 0xE0020 && r <= 0xE007F) || // tag characters
+		(r >= 0xE0100 && r <= 0xE01EF)
+}
+
+func regionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// pictographic reports the Extended_Pictographic property, see pictographic.go
+func pictographic(r rune) bool {
+	return unicode.Is(extendedPictographic, r)
+}
+
+func hangulL(r rune) bool {
+	return (r >= 0x1100 && r <= 0x115F) || (r >= 0xA960 && r <= 0xA97C)
+}
+
+func hangulV(r rune) bool {
+	return (r >= 0x1160 && r <= 0x11A7) || (r >= 0xD7B0 && r <= 0xD7C6)
+}
+
+func hangulT(r rune) bool {
+	return (r >= 0x11A8 && r <= 0x11FF) || (r >= 0xD7CB && r <= 0xD7FB)
+}
+
+// precomposed syllables come in blocks of 28: the LV syllable, which can
+// still take a vowel or trailing jamo, and 27 LVT syllables that can only
+// take trailing jamo
+func hangulLV(r rune) bool {
+	return r >= 0xAC00 && r <= 0xD7A3 && (r-0xAC00)%28 == 0
+}
+
+func hangulLVT(r rune) bool {
+	return r >= 0xAC00 && r <= 0xD7A3 && (r-0xAC00)%28 != 0
+}
@@ -0,0 +1,110 @@
+package chirplen
+
+import (
+	"strings"
+	"testing"
+)
+
+// the expected counts follow UAX #29 with the emoji-data.txt of Unicode
+// 15.0.0, check them against the new version before regenerating the table
+const testedUnicodeVersion = "15.0.0"
+
+func TestGraphemes(t *testing.T) {
+	if unicodeVersion != testedUnicodeVersion {
+		t.Fatalf("pictographic.go is generated from Unicode %s, the cases are checked against %s", unicodeVersion, testedUnicodeVersion)
+	}
+
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello", 5},
+		{"latin-1", "Grüße", 5},
+		{"precomposed accent", "é", 1},
+		{"combining accent", "e\u0301", 1},
+		{"stacked combining marks", "a\u0301\u0327\u0308b", 2},
+		{"combining mark at the start", "\u0301a", 2},
+		{"devanagari spacing mark", "क\u093f", 1},
+		{"CRLF", "a\r\nb", 3},
+		{"LFCR", "a\n\rb", 4},
+		{"CR LF CR LF", "\r\n\r\n", 2},
+		{"combining mark after a newline", "\n\u0301", 2},
+		{"tab", "a\tb", 3},
+		{"zero-width space", "a\u200bb", 3},
+		{"emoji", "😀", 1},
+		{"emoji with text presentation selector", "☺\ufe0f", 1},
+		{"keycap", "1\ufe0f\u20e3", 1},
+		{"skin tone", "👍\U0001f3fd", 1},
+		{"lone skin tone", "\U0001f3fd", 1},
+		{"two skin-toned emoji", "👍\U0001f3fd👍\U0001f3ff", 2},
+		{"ZWJ family", "👨\u200d👩\u200d👧\u200d👦", 1},
+		{"ZWJ with skin tone", "👩\U0001f3fd\u200d💻", 1},
+		{"ZWJ after a variation selector", "❤\ufe0f\u200d🔥", 1},
+		{"rainbow flag", "🏳\ufe0f\u200d🌈", 1},
+		{"ZWJ kiss with skin tones", "🧑\U0001f3fb\u200d❤\ufe0f\u200d💋\u200d🧑\U0001f3ff", 1},
+		{"ZWJ between letters", "a\u200db", 2},
+		{"ZWJ at the end", "👨\u200d", 1},
+		{"ZWJ before a letter", "👨\u200da", 2},
+		{"ZWJ with a pictograph outside the emoji blocks", "🐈\u200d⬛", 1},
+		{"ZWJ with an arrow", "🏃\u200d➡\ufe0f", 1},
+		{"ZWJ before a dingbat that isn't pictographic", "👨\u200d❶", 2},
+		{"ZWJ before an unassigned pictographic code point", "👨\u200d\U0001fc00", 1},
+		{"ZWJ family twice", "👨\u200d👩\u200d👧👨\u200d👩\u200d👧", 2},
+		{"flag", "\U0001f1e9\U0001f1ea", 1},
+		{"two flags", "\U0001f1e9\U0001f1ea\U0001f1eb\U0001f1f7", 2},
+		{"three regional indicators", "\U0001f1e9\U0001f1ea\U0001f1eb", 2},
+		{"flags split by text", "\U0001f1e9a\U0001f1ea", 3},
+		{"subdivision flag", "🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f", 1},
+		{"hangul syllables", "한국어", 3},
+		{"hangul jamo L V T", "\u1112\u1161\u11ab", 1},
+		{"hangul jamo L V", "\u1100\u1161", 1},
+		{"hangul LV + T", "가\u11a8", 1},
+		{"hangul LVT + T", "각\u11a8", 1},
+		{"hangul LVT + V", "각\u1161", 2},
+		{"hangul L + LVT", "\u1100각", 1},
+		{"hangul T + L", "\u11a8\u1100", 2},
+		{"CJK", "漢字", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Graphemes(tt.s); got != tt.want {
+				t.Errorf("Graphemes(%+q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCount(t *testing.T) {
+	family := "👨‍👩‍👧‍👦"
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"plain text", "hello world", 11},
+		{"link", "see https://example.com/a/very/long/path?with=query", 4 + URLWeight},
+		{"two links", "http://a.io http://b.io", URLWeight + 1 + URLWeight},
+		{"link without scheme", "example.com", 11},
+		{"140 letters", strings.Repeat("a", 140), 140},
+		{"141 letters", strings.Repeat("a", 141), 141},
+		{"140 ZWJ families", strings.Repeat(family, 140), 140},
+		{"141 ZWJ families", strings.Repeat(family, 141), 141},
+		{"140 flags", strings.Repeat("🇩🇪", 140), 140},
+		{"140 combining accents", strings.Repeat("é", 140), 140},
+		{"140 CRLFs", strings.Repeat("\r\n", 140), 140},
+		{"117 letters and a link", strings.Repeat("a", 116) + " https://example.com", 140},
+		{"118 letters and a link", strings.Repeat("a", 117) + " https://example.com", 141},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Count(tt.body); got != tt.want {
+				t.Errorf("Count = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
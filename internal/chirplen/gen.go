@@ -0,0 +1,180 @@
+//go:build ignore
+
+// gen writes pictographic.go, the Extended_Pictographic property from the
+// emoji-data.txt of unicodeVersion. Run it with go generate, or with
+// -data to read a copy of the file that was downloaded before.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const unicodeVersion = "15.0.0"
+
+const dataURL = "https://www.unicode.org/Public/" + unicodeVersion + "/ucd/emoji/emoji-data.txt"
+
+type codeRange struct {
+	lo, hi rune
+}
+
+func main() {
+	data := flag.String("data", "", "emoji-data.txt to read instead of downloading "+dataURL)
+	out := flag.String("o", "pictographic.go", "file to write")
+	flag.Parse()
+
+	r, err := open(*data)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer r.Close()
+
+	ranges, err := parse(r, "Extended_Pictographic")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := format.Source(render(ranges))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.WriteFile(*out, src, 0644)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func open(path string) (io.ReadCloser, error) {
+	if path != "" {
+		return os.Open(path)
+	}
+
+	resp, err := http.Get(dataURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", dataURL, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// parse collects the code points of a property from lines like
+// "1F300..1F30C  ; Extended_Pictographic# E0.6  [13] (🌀..🌌) cyclone..milky way"
+// and merges overlapping and adjacent ranges
+func parse(r io.Reader, property string) ([]codeRange, error) {
+	ranges := []codeRange{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		fields, _, _ := strings.Cut(scanner.Text(), "#")
+		points, prop, ok := strings.Cut(fields, ";")
+
+		if !ok || strings.TrimSpace(prop) != property {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(strings.TrimSpace(points), "..")
+
+		if !isRange {
+			last = first
+		}
+
+		lo, err := strconv.ParseUint(first, 16, 32)
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		hi, err := strconv.ParseUint(last, 16, 32)
+
+		if err != nil || hi < lo {
+			return nil, fmt.Errorf("line %d: bad range %q", line, points)
+		}
+
+		ranges = append(ranges, codeRange{rune(lo), rune(hi)})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no %s code points", property)
+	}
+
+	slices.SortFunc(ranges, func(a, b codeRange) int { return int(a.lo - b.lo) })
+	merged := ranges[:1]
+
+	for _, r := range ranges[1:] {
+		if last := &merged[len(merged)-1]; r.lo <= last.hi+1 {
+			last.hi = max(last.hi, r.hi)
+		} else {
+			merged = append(merged, r)
+		}
+	}
+
+	return merged, nil
+}
+
+// render writes the ranges as a unicode.RangeTable
+func render(ranges []codeRange) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "// Code generated by gen.go from %s. DO NOT EDIT.\n\n", dataURL)
+	fmt.Fprintf(&b, "package chirplen\n\nimport \"unicode\"\n\n")
+	fmt.Fprintf(&b, "// unicodeVersion is the version of emoji-data.txt the table was generated from\n")
+	fmt.Fprintf(&b, "const unicodeVersion = %q\n\n", unicodeVersion)
+	fmt.Fprintf(&b, "// extendedPictographic holds the runes that can start or continue an emoji ZWJ sequence\n")
+	fmt.Fprintf(&b, "var extendedPictographic = &unicode.RangeTable{\n")
+
+	latin := 0
+
+	// R16 holds the ranges below 0x10000, a range across the boundary is split
+	fmt.Fprintf(&b, "R16: []unicode.Range16{\n")
+
+	for _, r := range ranges {
+		if r.lo <= 0xFFFF {
+			fmt.Fprintf(&b, "{0x%04X, 0x%04X, 1},\n", r.lo, min(r.hi, 0xFFFF))
+		}
+
+		if r.hi <= unicode.MaxLatin1 {
+			latin++
+		}
+	}
+
+	fmt.Fprintf(&b, "},\nR32: []unicode.Range32{\n")
+
+	for _, r := range ranges {
+		if r.hi > 0xFFFF {
+			fmt.Fprintf(&b, "{0x%04X, 0x%04X, 1},\n", max(r.lo, 0x10000), r.hi)
+		}
+	}
+
+	fmt.Fprintf(&b, "},\nLatinOffset: %d,\n}\n", latin)
+
+	return b.Bytes()
+}
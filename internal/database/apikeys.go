@@ -0,0 +1,248 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+)
+
+const (
+	maxAPIKeys          = 25
+	maxAPIKeyNameLength = 100
+	// apiKeyTouchInterval limits how often using a key writes last_used_at
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrMissingScope  = errors.New("API key lacks the required scope")
+)
+
+// APIKey is a long-lived credential for bots. Only the hash of the secret is stored.
+type APIKey struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used_at"`
+
+	// Token is only set in the response that creates the key
+	Token string `json:"token,omitempty"`
+}
+
+// forOwner hides the hash of the secret from responses
+func (k APIKey) forOwner() APIKey {
+	k.Hash = ""
+	return k
+}
+
+// CreateAPIKey creates a named key with the given scopes. The returned key
+// carries the secret, which can't be recovered later.
+func (db *DB) CreateAPIKey(userID int, name string, scopes []string) (APIKey, error) {
+	name = strings.TrimSpace(name)
+
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		return APIKey{}, errors.New("name is required and at most 100 characters long")
+	}
+
+	if len(scopes) == 0 {
+		return APIKey{}, errors.New("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if !auth.ValidScope(scope) {
+			return APIKey{}, errors.New("unknown scope " + scope)
+		}
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return APIKey{}, err
+	}
+
+	max := 0
+	count := 0
+
+	for _, key := range dbStructure.APIKeys {
+		if key.ID > max {
+			max = key.ID
+		}
+
+		if key.UserID == userID {
+			count++
+		}
+	}
+
+	if count >= maxAPIKeys {
+		return APIKey{}, errors.New("too many API keys")
+	}
+
+	secret, err := randomHex(32)
+
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	token := auth.APIKeyPrefix + secret
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+
+	key := APIKey{
+		ID:        max + 1,
+		UserID:    userID,
+		Name:      name,
+		Scopes:    slices.Compact(scopes),
+		Prefix:    token[:len(auth.APIKeyPrefix)+6],
+		Hash:      hashToken(token),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	dbStructure.APIKeys[key.ID] = key
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return APIKey{}, err
+	}
+
+	key = key.forOwner()
+	key.Token = token
+
+	return key, nil
+}
+
+// GetAPIKeys returns the keys of a user, newest first
+func (db *DB) GetAPIKeys(userID int) ([]APIKey, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching API keys: %v", err)
+		return nil, err
+	}
+
+	keys := []APIKey{}
+
+	for _, key := range dbStructure.APIKeys {
+		if key.UserID == userID {
+			keys = append(keys, key.forOwner())
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b APIKey) int { return b.ID - a.ID })
+
+	return keys, nil
+}
+
+// DeleteAPIKey revokes a key of the user
+func (db *DB) DeleteAPIKey(userID int, id int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	key, ok := dbStructure.APIKeys[id]
+
+	if !ok || key.UserID != userID {
+		return false, nil
+	}
+
+	delete(dbStructure.APIKeys, id)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AuthenticateAPIKey returns the owner of a key that was granted scope and
+// records its use. Writes are throttled to one per apiKeyTouchInterval.
+func (db *DB) AuthenticateAPIKey(token string, scope string, now time.Time) (int, error) {
+	db.mux.RLock()
+	dbStructure, err := db.loadDB()
+	db.mux.RUnlock()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return 0, err
+	}
+
+	hash := hashToken(token)
+	id, key, ok := apiKeyByHash(dbStructure, hash)
+
+	if !ok {
+		return 0, ErrInvalidAPIKey
+	}
+
+	if !slices.Contains(key.Scopes, scope) {
+		return 0, ErrMissingScope
+	}
+
+	if key.LastUsed != nil && now.Sub(*key.LastUsed) < apiKeyTouchInterval {
+		return key.UserID, nil
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	// the key may have been deleted since the lookup
+	dbStructure, err = db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return 0, err
+	}
+
+	key, ok = dbStructure.APIKeys[id]
+
+	if !ok || key.Hash != hash {
+		return 0, ErrInvalidAPIKey
+	}
+
+	key.LastUsed = &now
+	dbStructure.APIKeys[id] = key
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return 0, err
+	}
+
+	return key.UserID, nil
+}
+
+// apiKeyByHash finds the key whose secret hashes to hash
+func apiKeyByHash(dbStructure DBStructure, hash string) (int, APIKey, bool) {
+	for id, key := range dbStructure.APIKeys {
+		if key.Hash == hash {
+			return id, key, true
+		}
+	}
+
+	return 0, APIKey{}, false
+}
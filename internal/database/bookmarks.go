@@ -0,0 +1,334 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"slices"
+)
+
+type List struct {
+	ID      int    `json:"id"`
+	Owner   int    `json:"owner_id"`
+	Name    string `json:"name"`
+	Members []int  `json:"members"`
+}
+
+// AddBookmark stores a chirp in the private bookmarks of a user
+func (db *DB) AddBookmark(userID int, chirpID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return err
+	}
+
+	if _, ok := visibleChirp(dbStructure, chirpID); !ok {
+		return errors.New("ID not found")
+	}
+
+	bookmarks := dbStructure.Bookmarks[userID]
+
+	if slices.Contains(bookmarks, chirpID) {
+		return nil
+	}
+
+	dbStructure.Bookmarks[userID] = append(bookmarks, chirpID)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// RemoveBookmark deletes a chirp from the bookmarks of a user
+func (db *DB) RemoveBookmark(userID int, chirpID int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	bookmarks := dbStructure.Bookmarks[userID]
+	i := slices.Index(bookmarks, chirpID)
+
+	if i == -1 {
+		return false, nil
+	}
+
+	dbStructure.Bookmarks[userID] = slices.Delete(bookmarks, i, i+1)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetBookmarks returns the bookmarked chirps of a user, newest bookmark first
+func (db *DB) GetBookmarks(userID int, limit int, offset int, hideSensitive bool) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching bookmarks in GetBookmarks: %v", err)
+		return nil, err
+	}
+
+	bookmarks := dbStructure.Bookmarks[userID]
+	chirps := []Chirp{}
+
+	for i := len(bookmarks) - 1; i >= 0; i-- {
+		chirp, ok := visibleChirp(dbStructure, bookmarks[i])
+
+		if ok && !(hideSensitive && chirp.needsWarning()) {
+			chirps = append(chirps, chirp.forViewer(dbStructure.Users[userID]))
+		}
+	}
+
+	return paginate(chirps, limit, offset), nil
+}
+
+// CreateList creates a new named list of accounts owned by a user
+func (db *DB) CreateList(userID int, name string) (List, error) {
+	if name == "" {
+		return List{}, errors.New("name is required")
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return List{}, err
+	}
+
+	max := 0
+
+	for _, list := range dbStructure.Lists {
+		if list.ID > max {
+			max = list.ID
+		}
+	}
+
+	list := List{
+		ID:      max + 1,
+		Owner:   userID,
+		Name:    name,
+		Members: []int{},
+	}
+
+	dbStructure.Lists[list.ID] = list
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return List{}, err
+	}
+
+	return list, nil
+}
+
+// GetLists returns all lists owned by a user
+func (db *DB) GetLists(userID int) ([]List, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching lists in GetLists: %v", err)
+		return nil, err
+	}
+
+	lists := []List{}
+
+	for _, list := range dbStructure.Lists {
+		if list.Owner == userID {
+			lists = append(lists, list)
+		}
+	}
+
+	slices.SortFunc(lists, func(a, b List) int { return a.ID - b.ID })
+
+	return lists, nil
+}
+
+// GetList returns a single list if it is owned by the user
+func (db *DB) GetList(userID int, listID int) (List, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching list in GetList: %v", err)
+		return List{}, err
+	}
+
+	list, ok := dbStructure.Lists[listID]
+
+	if !ok || list.Owner != userID {
+		return List{}, errors.New("list not found")
+	}
+
+	return list, nil
+}
+
+// DeleteList removes a list owned by the user
+func (db *DB) DeleteList(userID int, listID int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	list, ok := dbStructure.Lists[listID]
+
+	if !ok || list.Owner != userID {
+		return false, nil
+	}
+
+	delete(dbStructure.Lists, listID)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AddListMember adds an account to a list owned by the user
+func (db *DB) AddListMember(userID int, listID int, memberID int) (List, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return List{}, err
+	}
+
+	list, ok := dbStructure.Lists[listID]
+
+	if !ok || list.Owner != userID {
+		return List{}, errors.New("list not found")
+	}
+
+	if _, ok := dbStructure.Users[memberID]; !ok {
+		return List{}, errors.New("user not found")
+	}
+
+	if !slices.Contains(list.Members, memberID) {
+		list.Members = append(list.Members, memberID)
+	}
+
+	dbStructure.Lists[listID] = list
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return List{}, err
+	}
+
+	return list, nil
+}
+
+// RemoveListMember removes an account from a list owned by the user
+func (db *DB) RemoveListMember(userID int, listID int, memberID int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	list, ok := dbStructure.Lists[listID]
+
+	if !ok || list.Owner != userID {
+		return false, nil
+	}
+
+	i := slices.Index(list.Members, memberID)
+
+	if i == -1 {
+		return false, nil
+	}
+
+	list.Members = slices.Delete(list.Members, i, i+1)
+	dbStructure.Lists[listID] = list
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetListTimeline returns the chirps of all members of a list, newest first
+func (db *DB) GetListTimeline(userID int, listID int, limit int, offset int, hideSensitive bool) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching list timeline: %v", err)
+		return nil, err
+	}
+
+	list, ok := dbStructure.Lists[listID]
+
+	if !ok || list.Owner != userID {
+		return nil, errors.New("list not found")
+	}
+
+	chirps := []Chirp{}
+
+	for id, chirp := range dbStructure.Chirps {
+		if !slices.Contains(list.Members, chirp.Author) || (hideSensitive && chirp.needsWarning()) {
+			continue
+		}
+
+		if chirp, ok := visibleChirp(dbStructure, id); ok {
+			chirps = append(chirps, chirp.forViewer(dbStructure.Users[userID]))
+		}
+	}
+
+	slices.SortFunc(chirps, func(a, b Chirp) int { return b.ID - a.ID })
+
+	return paginate(chirps, limit, offset), nil
+}
@@ -1,113 +1,204 @@
 package database
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"log"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/nilsboi/Chirpy/internal/auth"
+	"github.com/nilsboi/Chirpy/internal/passwords"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type DB struct {
 	path string
-	mux  *sync.RWMutex
+	// mux is shared by every DB of the same path. Methods hold it across
+	// the whole load, change and write, loadDB and writeDB don't lock.
+	mux *sync.RWMutex
 }
 
 type DBStructure struct {
-	Chirps map[int]Chirp    `json:"chirps"`
-	Users  map[int]User     `json:"users"`
-	Tokens map[string]Token `json:"tokens"`
+	Chirps    map[int]Chirp          `json:"chirps"`
+	Users     map[int]User           `json:"users"`
+	Tokens    map[string]Token       `json:"tokens"`
+	Bookmarks map[int][]int          `json:"bookmarks"`
+	Lists     map[int]List           `json:"lists"`
+	Scheduled map[int]ScheduledChirp `json:"scheduled"`
+	Drafts    map[int]Draft          `json:"drafts"`
+	Blocks    map[int][]int          `json:"blocks"`
+	Likes     map[int][]int          `json:"likes"`
+	Rechirps  map[int][]int          `json:"rechirps"`
+	Sessions  map[string]Session     `json:"sessions"`
+	// PasswordResets are keyed by the SHA-256 of the reset token
+	PasswordResets map[string]PasswordReset `json:"password_resets"`
+	// EmailVerifications are keyed by the SHA-256 of the verification token
+	EmailVerifications map[string]EmailVerification `json:"email_verifications"`
+	MFA                map[int]MFA                  `json:"mfa"`
+	// MFAChallenges are keyed by the SHA-256 of the challenge token
+	MFAChallenges map[string]MFAChallenge `json:"mfa_challenges"`
+	APIKeys       map[int]APIKey          `json:"api_keys"`
+	// LoginAttempts are keyed by "account:<email>" or "ip:<address>"
+	LoginAttempts map[string]LoginAttempt `json:"login_attempts"`
+	// NextChirpID is the id of the next chirp, ids of deleted chirps stay used
+	NextChirpID int `json:"next_chirp_id"`
 }
 
 type Chirp struct {
-	ID     int    `json:"id"`
-	Body   string `json:"body"`
-	Author int    `json:"author_id"`
+	ID        int        `json:"id"`
+	Body      string     `json:"body"`
+	Author    int        `json:"author_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Poll      *Poll      `json:"poll,omitempty"`
+	Media     []Media    `json:"media,omitempty"`
+
+	ContentWarning string `json:"content_warning,omitempty"`
+	Sensitive      bool   `json:"sensitive"`
+	// Collapsed tells clients to hide the body behind the content warning
+	Collapsed bool `json:"collapsed"`
+	// Flagged marks chirps the content filter wants a moderator to look at
+	Flagged bool `json:"flagged_for_review,omitempty"`
+
+	ReplyTo  int     `json:"reply_to,omitempty"`
+	Likes    int     `json:"likes"`
+	Replies  int     `json:"replies"`
+	Rechirps int     `json:"rechirps"`
+	Score    float64 `json:"score"`
+}
+
+// ChirpOptions holds the optional settings of a new chirp
+type ChirpOptions struct {
+	ExpiresIn      time.Duration
+	Poll           *Poll
+	ContentWarning string
+	Sensitive      bool
+	Flagged        bool
+	ReplyTo        int
 }
 
 type Token struct {
 	TokenString string    `json:"tokenString"`
 	Expires     time.Time `json:"expires"`
 	UserID      int       `json:"user"`
+	// Family groups all refresh tokens that descend from the same login
+	Family     string     `json:"family,omitempty"`
+	Revoked    *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy string     `json:"replaced_by,omitempty"`
 }
 
 type User struct {
 	ID           int     `json:"id"`
 	Email        string  `json:"email"`
 	Password     *string `json:"password,omitempty"`
-	Token        string `json:"token,omitempty"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	Premium bool `json:"is_chirpy_red"`
+	Token        string  `json:"token,omitempty"`
+	RefreshToken string  `json:"refresh_token,omitempty"`
+	Premium      bool    `json:"is_chirpy_red"`
+	Pinned       []int   `json:"pinned_chirps,omitempty"`
+	Username     string  `json:"username,omitempty"`
+	DisplayName  string  `json:"display_name,omitempty"`
+
+	AutoExpandSensitive bool `json:"auto_expand_sensitive"`
+
+	EmailVerified bool `json:"email_verified"`
+	// PendingEmail replaces Email once the user confirms it
+	PendingEmail string `json:"pending_email,omitempty"`
+
+	MFAEnabled bool `json:"mfa_enabled"`
+	// Role is one of the auth roles, accounts from before roles existed have none
+	Role string `json:"role,omitempty"`
+	// MFAToken is handed out by Login instead of tokens when a second factor is needed
+	MFAToken string `json:"mfa_token,omitempty"`
 }
 
 // CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string, token string) (Chirp, error) {
+func (db *DB) CreateChirp(body string, userID int, opts ChirpOptions) (Chirp, error) {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
-	users, err := db.GetUsers()
+	dbStructure, err := db.loadDB()
 
 	if err != nil {
-		log.Printf("Error fetching users in database: %v", err)
+		log.Printf("Error reading database file: %v", err)
 		return Chirp{}, err
 	}
 
-	for _, user := range users {
-		
-		if user.Token == token {
-			chirps, err1 := db.GetChirps("", "")
+	user, ok := dbStructure.Users[userID]
 
-			if err1 != nil {
-				log.Printf("Error fetching chirps in database: %v", err1)
-				return Chirp{}, err1
-			}
-			max := 0
+	if !ok {
+		return Chirp{}, errors.New("unauthorized")
+	}
 
-			for _, chirp := range chirps {
-				if chirp.ID > max {
-					max = chirp.ID
-				}
-			}
+	if _, ok := visibleChirp(dbStructure, opts.ReplyTo); opts.ReplyTo != 0 && !ok {
+		return Chirp{}, errors.New("chirp to reply to not found")
+	}
 
-			id := max + 1
+	chirp := insertChirp(&dbStructure, body, user.ID, opts)
 
-			chirp := Chirp{
-				ID:   id,
-				Body: body,
-				Author: user.ID,
-			}
+	err = db.writeDB(dbStructure)
 
-			dbStructure, err2 := db.loadDB()
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return Chirp{}, err
+	}
 
-			if err2 != nil {
-				log.Printf("Error reading database file: %v", err2)
-				return Chirp{}, err2
-			}
+	return chirp.forViewer(user), nil
+}
 
-			dbStructure.Chirps[id] = chirp
+// insertChirp adds a chirp with the next id to the structure. Ids are never
+// reused, so a bookmark or link to a deleted chirp can't lead to a new one.
+// An ExpiresIn of zero creates a chirp that never expires. A reply to a
+// chirp that is gone by now is stored as a regular chirp.
+func insertChirp(dbStructure *DBStructure, body string, author int, opts ChirpOptions) Chirp {
+	// databases from before NextChirpID only know their highest id
+	id := max(dbStructure.NextChirpID, 1)
 
-			err3 := db.writeDB(dbStructure)
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.ID >= id {
+			id = chirp.ID + 1
+		}
+	}
 
-			if err3 != nil {
-				log.Printf("Error writing database file: %v", err3)
-				return Chirp{}, err3
-			}
+	dbStructure.NextChirpID = id + 1
 
-			return chirp, nil
+	chirp := Chirp{
+		ID:        id,
+		Body:      body,
+		Author:    author,
+		CreatedAt: time.Now().UTC(),
+		Poll:      opts.Poll,
 
-		}
+		ContentWarning: opts.ContentWarning,
+		Sensitive:      opts.Sensitive,
+		Flagged:        opts.Flagged,
+	}
+
+	if opts.ExpiresIn > 0 {
+		expiresAt := chirp.CreatedAt.Add(opts.ExpiresIn)
+		chirp.ExpiresAt = &expiresAt
+	}
+
+	if _, ok := visibleChirp(*dbStructure, opts.ReplyTo); opts.ReplyTo != 0 && ok {
+		chirp.ReplyTo = opts.ReplyTo
+		countReply(*dbStructure, chirp, 1)
 	}
-	return Chirp{}, errors.New("unauthorized")
+
+	chirp.updateScore()
+	dbStructure.Chirps[chirp.ID] = chirp
+
+	return chirp
 }
 
-// GetChirps returns all chirps in the database
-func (db *DB) GetChirps(id string, s string) ([]Chirp, error) {
+// GetChirps returns all chirps in the database, prepared for the viewer, 0 for anonymous requests.
+// With hideSensitive set, chirps behind a content warning or flagged as sensitive are left out.
+func (db *DB) GetChirps(id string, s string, hideSensitive bool, viewer int) ([]Chirp, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 	dbStructure, err := db.loadDB()
@@ -118,11 +209,14 @@ func (db *DB) GetChirps(id string, s string) ([]Chirp, error) {
 	}
 
 	chirps := []Chirp{}
+	user := dbStructure.Users[viewer]
 
 	if id == "" {
 
 		for _, chirp := range dbStructure.Chirps {
-			chirps = append(chirps, chirp)
+			if !chirp.expired() && !(hideSensitive && chirp.needsWarning()) {
+				chirps = append(chirps, chirp.forViewer(user))
+			}
 		}
 	} else {
 
@@ -134,23 +228,40 @@ func (db *DB) GetChirps(id string, s string) ([]Chirp, error) {
 		}
 
 		for _, chirp := range dbStructure.Chirps {
-			if i == chirp.Author {
-				chirps = append(chirps, chirp)
+			if i == chirp.Author && !chirp.expired() && !(hideSensitive && chirp.needsWarning()) {
+				chirps = append(chirps, chirp.forViewer(user))
 			}
 		}
 	}
 
-	if s== "desc" {
+	if s == "desc" {
 		sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
 	}
 
+	if s == "popular" {
+		sortPopular(chirps)
+	}
+
+	if id != "" {
+		i, _ := strconv.Atoi(id)
+		pinned := dbStructure.Users[i].Pinned
+
+		// pinned chirps go first, in the order they were pinned
+		sort.SliceStable(chirps, func(a, b int) bool {
+			pa, pb := slices.Index(pinned, chirps[a].ID), slices.Index(pinned, chirps[b].ID)
+			if pa == -1 || pb == -1 {
+				return pa != -1 && pb == -1
+			}
+			return pa < pb
+		})
+	}
+
 	return chirps, nil
 
 }
 
-// Get chrips bei id
-
-func (db *DB) GetChirp(id string) (Chirp, error) {
+// GetChirp returns a chirp by id, prepared for the viewer, 0 for anonymous requests.
+func (db *DB) GetChirp(id string, viewer int) (Chirp, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 	dbStructure, err := db.loadDB()
@@ -167,54 +278,173 @@ func (db *DB) GetChirp(id string) (Chirp, error) {
 		return Chirp{}, err
 	}
 
-	for _, chirp := range dbStructure.Chirps {
+	chirp, ok := visibleChirp(dbStructure, find)
 
-		if chirp.ID == find {
-			return chirp, nil
-		}
+	if ok {
+		return chirp.forViewer(dbStructure.Users[viewer]), nil
 	}
 
 	return Chirp{}, errors.New("ID not found")
 }
 
-func (db *DB) DeleteChirp(tokenString string) (bool, error) {
+// GetChirpsByID resolves chirp ids in the given order, skipping the ones
+// that no longer exist or may not be shown
+func (db *DB) GetChirpsByID(ids []int, viewer int) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-	users, err := db.GetUsers()
+	dbStructure, err := db.loadDB()
 
 	if err != nil {
-		log.Printf("Error loading tokens: %v", err)
-		return false, err
+		log.Printf("Error fetching chirps in GetChirpsByID: %v", err)
+		return nil, err
 	}
-	
-	for _, user := range(users) {
-		if user.Token == tokenString {
-			
-			dbStructure, err := db.loadDB()
 
-			if err != nil {
-				log.Printf("Error loading db: %v", err)
-				return false, err
-			}
-		
-			if dbStructure.Chirps[len(dbStructure.Chirps)].Author == user.ID { 
-			delete(dbStructure.Chirps , len(dbStructure.Chirps) + 1)
-
-			db.writeDB(dbStructure)
-			return true, nil
-			} else {
-				return false, nil
-			}
+	chirps := []Chirp{}
+
+	for _, id := range ids {
+		if chirp, ok := visibleChirp(dbStructure, id); ok {
+			chirps = append(chirps, chirp.forViewer(dbStructure.Users[viewer]))
 		}
 	}
 
-	return false, nil 
+	return chirps, nil
+}
+
+// VisibleChirpIDs returns the ids of all chirps that may be shown, for
+// callers that filter ids of their own, like search, before paging
+func (db *DB) VisibleChirpIDs() (map[int]bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching chirps in VisibleChirpIDs: %v", err)
+		return nil, err
+	}
+
+	ids := make(map[int]bool, len(dbStructure.Chirps))
+
+	for id := range dbStructure.Chirps {
+		if _, ok := visibleChirp(dbStructure, id); ok {
+			ids[id] = true
+		}
+	}
+
+	return ids, nil
+}
+
+// visibleChirp looks up a chirp by id and reports whether it may be shown.
+// Every read path that resolves chirps by id should go through here.
+func visibleChirp(dbStructure DBStructure, id int) (Chirp, bool) {
+	chirp, ok := dbStructure.Chirps[id]
+
+	if !ok || chirp.expired() {
+		return Chirp{}, false
+	}
+
+	return chirp, true
+}
+
+// expired reports whether an expiry time has passed. Refresh tokens and
+// ephemeral chirps share it so both use the same clock and comparison.
+func expired(expires time.Time) bool {
+	return expiredAt(expires, time.Now().UTC())
+}
+
+// expiredAt is expired for jobs that run with their own now
+func expiredAt(expires time.Time, now time.Time) bool {
+	return now.After(expires)
+}
+
+func (c Chirp) expired() bool {
+	return c.expiredAt(time.Now().UTC())
+}
+
+func (c Chirp) expiredAt(now time.Time) bool {
+	return c.ExpiresAt != nil && expiredAt(*c.ExpiresAt, now)
+}
+
+func (c Chirp) needsWarning() bool {
+	return c.Sensitive || c.ContentWarning != ""
+}
+
+// forViewer prepares a chirp for the given user, the zero User for anonymous
+// requests. It collapses sensitive content unless the viewer opted out and
+// hides poll details the viewer may not see yet.
+func (c Chirp) forViewer(viewer User) Chirp {
+	c.Collapsed = c.needsWarning() && !viewer.AutoExpandSensitive
+
+	// only authors learn that their chirp is waiting for review
+	if viewer.ID == 0 || viewer.ID != c.Author {
+		c.Flagged = false
+	}
+
+	if c.Poll != nil {
+		c.Poll = c.Poll.forViewer(viewer.ID)
+	}
+
+	return c
+}
+
+// paginate returns the window of chirps described by limit and offset
+func paginate(chirps []Chirp, limit int, offset int) []Chirp {
+	if offset >= len(chirps) {
+		return []Chirp{}
+	}
+
+	end := offset + limit
+
+	if limit <= 0 || end > len(chirps) {
+		end = len(chirps)
+	}
+
+	return chirps[offset:end]
+}
+
+// DeleteChirp removes a chirp if it was written by the user
+func (db *DB) DeleteChirp(userID int, chirpID int) (bool, error) {
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error loading db: %v", err)
+		return false, err
+	}
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+
+	if !ok || chirp.Author != userID {
+		return false, nil
+	}
+
+	removeChirp(dbStructure, chirp)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
 }
 
 // ensureDB creates a new database file if it doesn't exist
 func (db *DB) ensureDB() error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
-	err := os.WriteFile(db.path, []byte(`{ "chirps": {}, "users": {}, "tokens": {} }`), 0666)
+
+	// another connection may have created it in the meantime
+	if _, err := os.Stat(db.path); err == nil {
+		return nil
+	}
+
+	err := os.WriteFile(db.path, []byte(`{ "chirps": {}, "users": {}, "tokens": {}, "bookmarks": {}, "lists": {}, "scheduled": {}, "drafts": {}, "blocks": {}, "likes": {}, "rechirps": {}, "sessions": {}, "password_resets": {}, "email_verifications": {}, "mfa": {}, "mfa_challenges": {}, "api_keys": {}, "login_attempts": {} }`), 0666)
 
 	if err != nil {
 		return err
@@ -223,11 +453,8 @@ func (db *DB) ensureDB() error {
 	return nil
 }
 
-// loadDB reads the database file into memory
+// loadDB reads the database file into memory. The caller holds db.mux.
 func (db *DB) loadDB() (DBStructure, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
-
 	data, err1 := os.ReadFile(db.path)
 
 	if err1 != nil {
@@ -243,14 +470,69 @@ func (db *DB) loadDB() (DBStructure, error) {
 		return DBStructure{}, err2
 	}
 
+	// database files written by older versions lack the newer collections
+	if dbStructure.Bookmarks == nil {
+		dbStructure.Bookmarks = map[int][]int{}
+	}
+
+	if dbStructure.Lists == nil {
+		dbStructure.Lists = map[int]List{}
+	}
+
+	if dbStructure.Scheduled == nil {
+		dbStructure.Scheduled = map[int]ScheduledChirp{}
+	}
+
+	if dbStructure.Drafts == nil {
+		dbStructure.Drafts = map[int]Draft{}
+	}
+
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = map[int][]int{}
+	}
+
+	if dbStructure.Likes == nil {
+		dbStructure.Likes = map[int][]int{}
+	}
+
+	if dbStructure.Rechirps == nil {
+		dbStructure.Rechirps = map[int][]int{}
+	}
+
+	if dbStructure.Sessions == nil {
+		dbStructure.Sessions = map[string]Session{}
+	}
+
+	if dbStructure.PasswordResets == nil {
+		dbStructure.PasswordResets = map[string]PasswordReset{}
+	}
+
+	if dbStructure.EmailVerifications == nil {
+		dbStructure.EmailVerifications = map[string]EmailVerification{}
+	}
+
+	if dbStructure.MFA == nil {
+		dbStructure.MFA = map[int]MFA{}
+	}
+
+	if dbStructure.MFAChallenges == nil {
+		dbStructure.MFAChallenges = map[string]MFAChallenge{}
+	}
+
+	if dbStructure.APIKeys == nil {
+		dbStructure.APIKeys = map[int]APIKey{}
+	}
+
+	if dbStructure.LoginAttempts == nil {
+		dbStructure.LoginAttempts = map[string]LoginAttempt{}
+	}
+
 	return dbStructure, nil
 }
 
-// writeDB writes the database file to disk
+// writeDB writes the database file to disk. The caller holds db.mux for
+// writing since it loaded the structure.
 func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
-
 	data, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
@@ -264,13 +546,17 @@ func (db *DB) writeDB(dbStructure DBStructure) error {
 	return nil
 }
 
+// locks holds the mutex of every database path, handlers open a new DB per request
+var locks sync.Map
+
 // NewDB creates a new database connection
 // and creates the database file if it doesn't exist
 func NewDB(path string) (*DB, error) {
+	mux, _ := locks.LoadOrStore(path, &sync.RWMutex{})
 
 	newDatabase := DB{
 		path: path,
-		mux:  &sync.RWMutex{},
+		mux:  mux.(*sync.RWMutex),
 	}
 
 	_, err := os.ReadFile(path)
@@ -286,28 +572,21 @@ func NewDB(path string) (*DB, error) {
 	return &newDatabase, nil
 }
 
-func (db *DB) CreateUser(email string, password string) (User, error) {
-	users, err1 := db.GetUsers()
+// CreateUser registers an account. The password has to pass policy.
+func (db *DB) CreateUser(email string, password string, policy passwords.Policy) (User, error) {
+	email, err := NormaliseEmail(email)
 
-	if err1 != nil {
-		log.Printf("Error fetching users in database: %v", err1)
-		return User{}, err1
+	if err != nil {
+		return User{}, err
 	}
 
-	max := 0
+	err = policy.Check(password, email)
 
-	for _, user := range users {
-		if user.ID > max {
-			max = user.ID
-		}
-
-		if user.Email == email {
-			return User{}, errors.New("User already registered")
-		}
+	if err != nil {
+		return User{}, err
 	}
 
-	id := max + 1
-
+	// bcrypt is slow, so it runs before the database is locked
 	password, err4 := HashPassword(password)
 
 	if err4 != nil {
@@ -315,12 +594,8 @@ func (db *DB) CreateUser(email string, password string) (User, error) {
 		return User{}, err4
 	}
 
-	user := User{
-		ID:       id,
-		Email:    email,
-		Password: &password,
-		Premium: false,
-	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
 	dbStructure, err2 := db.loadDB()
 
@@ -329,6 +604,27 @@ func (db *DB) CreateUser(email string, password string) (User, error) {
 		return User{}, err2
 	}
 
+	max := 0
+
+	for _, user := range dbStructure.Users {
+		if user.ID > max {
+			max = user.ID
+		}
+
+		if strings.EqualFold(user.Email, email) {
+			return User{}, ErrEmailTaken
+		}
+	}
+
+	id := max + 1
+
+	user := User{
+		ID:       id,
+		Email:    email,
+		Password: &password,
+		Premium:  false,
+	}
+
 	dbStructure.Users[id] = user
 
 	err3 := db.writeDB(dbStructure)
@@ -363,6 +659,28 @@ func (db *DB) GetUsers() ([]User, error) {
 	return users, nil
 }
 
+// GetUser returns a single user without the password hash
+func (db *DB) GetUser(id int) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching user in GetUser: %v", err)
+		return User{}, err
+	}
+
+	user, ok := dbStructure.Users[id]
+
+	if !ok {
+		return User{}, errors.New("User not found")
+	}
+
+	user.Password = nil
+	return user, nil
+}
+
 func (db *DB) GetTokens() ([]Token, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
@@ -384,215 +702,246 @@ func (db *DB) GetTokens() ([]Token, error) {
 
 }
 
-func (db *DB) Login(email string, password string, key string) (User, error) {
+// Login checks the credentials and starts a session. expiresIn is the access
+// token lifetime the client asked for, it is clamped to the policy of its type.
+// Users with two-factor authentication get an MFA challenge instead of tokens.
+// Every wrong email or password returns ErrInvalidCredentials after the same
+// bcrypt work. Repeated failures lock the account and the IP for an
+// exponentially growing time, the failure that starts a lock and every
+// attempt during it return a *LockedError.
+func (db *DB) Login(email string, password string, keys *auth.Keyring, policy auth.Policy, client Client, expiresIn time.Duration, now time.Time) (User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	accountKey, ipKey := attemptKeys(email, client.IP)
 
-	users, err1 := db.GetUsers()
+	user, found, wait, err := db.beginLoginAttempt(email, accountKey, ipKey, now)
 
-	if err1 != nil {
-		log.Printf("Error fetching users in database: %v", err1)
-		return User{}, err1
+	if err != nil {
+		return User{}, err
 	}
 
-	for _, user := range users {
-		if user.Email == email {
-			check := CheckPasswordHash(password, *user.Password)
-
-			if !check {
-				log.Print("Credentials not valid")
-				return User{}, errors.New("Problem with login")
-			}
-
-			claims := &jwt.RegisteredClaims{
-				IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-				ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Second * time.Duration(3600))), //TODO und Expires richtig implementiren
-				Issuer:    "chirpy",
-				Subject:   strconv.Itoa(user.ID),
-			}
-
-			mySigningKey := []byte(key)
+	hash := dummyPasswordHash()
 
-			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-			ss, err := token.SignedString(mySigningKey)
-			if err != nil {
-				log.Print("Error signing token")
-				return User{}, errors.New("Problem with Token")
-			}
+	if found {
+		hash = *user.Password
+	}
 
-			dbStructure, err2 := db.loadDB()
+	// bcrypt runs for unknown emails too, so timing doesn't reveal which exist
+	if !CheckPasswordHash(password, hash) || !found {
+		log.Printf("Failed login from %s", client.IP)
 
-			if err2 != nil {
-				log.Print("Error Loadingdb")
-				return User{}, errors.New("Problem with loading DB")
-			}
+		if wait > 0 {
+			return User{}, &LockedError{RetryAfter: wait}
+		}
 
-			newToken := Token{}
-			bytes := make([]byte, 32) // 256 bits
-			_, err5 := rand.Read(bytes)
-			if err5 != nil {
-				return User{}, err5
-			}
+		return User{}, ErrInvalidCredentials
+	}
 
-			newToken.TokenString = hex.EncodeToString(bytes)
-			newToken.Expires = time.Now().Add(time.Hour * time.Duration(1440))
-			newToken.UserID = user.ID
+	// the password check is slow, state changes happen on a fresh copy
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
-			dbStructure.Tokens[hex.EncodeToString(bytes)] = newToken
+	dbStructure, err := db.loadDB()
 
-			user.Token = ss
-			user.RefreshToken = newToken.TokenString
-			
-			dbStructure.Users[user.ID] = user
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
 
-			err3 := db.writeDB(dbStructure)
+	// the IP only gets back the failure of this attempt, logging into an own
+	// account mustn't reset the failures of others
+	refundFailure(dbStructure, ipKey, ipFreeAttempts)
+	user = dbStructure.Users[user.ID]
 
-			if err3 != nil {
-				log.Printf("Error writing database file: %v", err3)
-				return User{}, err3
-			}
+	// with a second factor the account failures stay until the code is right,
+	// otherwise the password alone would buy unlimited code guesses
+	if user.MFAEnabled {
+		user.MFAToken, err = createMFAChallenge(dbStructure, user.ID, client, expiresIn, now)
+	} else {
+		delete(dbStructure.LoginAttempts, accountKey)
+		user, err = startSession(dbStructure, user, keys, policy, client, expiresIn, now)
+	}
 
-			user.Token = ss
-			user.Password = nil
-			user.RefreshToken = newToken.TokenString
+	if err != nil {
+		return User{}, err
+	}
 
-			return user, nil
+	err = db.writeDB(dbStructure)
 
-		}
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
 	}
 
-	return User{}, errors.New("User not found")
+	user.Password = nil
+
+	return user, nil
 }
 
-func (db *DB) UpdateUser(email string, password string, id int) (User, error) {
+// beginLoginAttempt refuses locked out logins and otherwise counts the attempt
+// as failed before the password is checked. Checking and counting under one
+// lock keeps parallel attempts from all slipping past the lockout. Login
+// refunds the failure once the password turns out to be right. The returned
+// duration is the lock the attempt starts if the password is wrong.
+func (db *DB) beginLoginAttempt(email string, accountKey string, ipKey string, now time.Time) (User, bool, time.Duration, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
-	users, err1 := db.GetUsers()
+	dbStructure, err := db.loadDB()
 
-	if err1 != nil {
-		log.Printf("Error fetching users in database: %v", err1)
-		return User{}, err1
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, false, 0, err
 	}
 
-	for _, user := range users {
-		if user.ID == id {
+	if wait := lockout(dbStructure, now, accountKey, ipKey); wait > 0 {
+		return User{}, false, 0, &LockedError{RetryAfter: wait}
+	}
 
-			dbStructure, err2 := db.loadDB()
+	recordFailure(dbStructure, accountKey, accountFreeAttempts, now)
+	recordFailure(dbStructure, ipKey, ipFreeAttempts, now)
 
-			if err2 != nil {
-				log.Printf("Error reading database file: %v", err2)
-				return User{}, err2
-			}
+	err = db.writeDB(dbStructure)
 
-			password, err4 := HashPassword(password)
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, false, 0, err
+	}
 
-			if err4 != nil {
-				log.Printf("Error hashing password: %v", err4)
-				return User{}, err4
-			}
+	user, found := userByEmail(dbStructure, email)
 
-			user.Email = email
-			user.Password = &password
-			dbStructure.Users[id] = user
+	return user, found && user.Password != nil, lockout(dbStructure, now, accountKey, ipKey), nil
+}
 
-			err3 := db.writeDB(dbStructure)
+// startSession creates a session for a user who passed every login step and
+// returns the user with its access and refresh token
+func startSession(dbStructure DBStructure, user User, keys *auth.Keyring, policy auth.Policy, client Client, expiresIn time.Duration, now time.Time) (User, error) {
+	lifetimes := policy.For(client.Type)
 
-			if err3 != nil {
-				log.Printf("Error writing database file: %v", err3)
-				return User{}, err3
-			}
+	session, err := createSession(dbStructure, user.ID, client, lifetimes, expiresIn, now)
+	if err != nil {
+		return User{}, err
+	}
 
-			user.Password = nil
-			return user, nil
+	// access tokens are validated by signature and never stored,
+	// refresh tokens only live in the token table because they rotate
+	newToken, err := issueRefreshToken(dbStructure, user.ID, session.ID, lifetimes.Refresh, now)
+	if err != nil {
+		return User{}, err
+	}
 
-		}
+	ss, err := auth.IssueAccessToken(user.ID, session.ID, user.role(), keys, time.Duration(session.AccessLifetime)*time.Second)
+	if err != nil {
+		log.Print("Error signing token")
+		return User{}, errors.New("Problem with Token")
 	}
-	return User{}, errors.New("problem with updating credentials")
+
+	user.Token = ss
+	user.RefreshToken = newToken.TokenString
+
+	return user, nil
 }
 
-func (db *DB) RefreshToken(refreshToken string, key string) (string, error) {
-	tokens, err := db.GetTokens()
+// UpdateUser sets the credentials of a user. A new email only becomes
+// pending, it replaces the current one once it has been verified. The
+// password has to pass policy.
+func (db *DB) UpdateUser(email string, password string, id int, policy passwords.Policy) (User, error) {
+	email, err := NormaliseEmail(email)
+
 	if err != nil {
-		log.Printf("Error fetching tokens: %v", err)
-		return "", err
+		return User{}, err
 	}
 
-	for _, token := range tokens {
-		if token.TokenString == refreshToken {
+	err = policy.Check(password, email)
 
-			if time.Now().UTC().After(token.Expires) {
-				return "", errors.New("expired")
-			}
-
-			claims := &jwt.RegisteredClaims{
-				IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-				ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Second * time.Duration(3600))),
-				Issuer:    "chirpy",
-				Subject:   strconv.Itoa(token.UserID),
-			}
+	if err != nil {
+		return User{}, err
+	}
 
-			mySigningKey := []byte(key)
+	// bcrypt is slow, so it runs before the database is locked
+	password, err4 := HashPassword(password)
 
-			tokenA := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-			ss, err := tokenA.SignedString(mySigningKey)
-			if err != nil {
-				log.Print("Error signing token")
-				return "", errors.New("Problem with access token")
-			}
+	if err4 != nil {
+		log.Printf("Error hashing password: %v", err4)
+		return User{}, err4
+	}
 
-			dbStructure, err := db.loadDB()
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
-			if err != nil {
-				log.Printf("Error fetching DB: %v", err)
-				return "", err
-			}
+	dbStructure, err2 := db.loadDB()
 
-			user := dbStructure.Users[token.UserID]
+	if err2 != nil {
+		log.Printf("Error reading database file: %v", err2)
+		return User{}, err2
+	}
 
-			user.Token = ss
+	user, ok := dbStructure.Users[id]
 
-			dbStructure.Users[token.UserID] = user
+	if !ok {
+		return User{}, errors.New("problem with updating credentials")
+	}
 
-			err2 := db.writeDB(dbStructure)
+	if strings.EqualFold(user.Email, email) {
+		user.PendingEmail = ""
+	} else if emailTaken(dbStructure, email, id) {
+		return User{}, ErrEmailTaken
+	} else {
+		user.PendingEmail = email
+	}
 
-			if err2 != nil {
-				log.Print("Error saving access token")
-				return "", errors.New("error saving access token")
-			}
+	user.Password = &password
+	dbStructure.Users[id] = user
 
-			return ss, nil
-		}
+	err3 := db.writeDB(dbStructure)
 
+	if err3 != nil {
+		log.Printf("Error writing database file: %v", err3)
+		return User{}, err3
 	}
-	return "", errors.New("invalid refresh token")
+
+	user.Password = nil
+	user.RefreshToken = ""
+	return user, nil
 }
 
-func (db *DB) RevokeToken(token string) (bool, error) {
+// UpdatePreferences stores the display preferences of a user
+func (db *DB) UpdatePreferences(id int, autoExpandSensitive bool) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
 	dbStructure, err := db.loadDB()
 
 	if err != nil {
-		log.Print("Error saving access token")
-		return false, errors.New("error revoking token")
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
 	}
 
-	val, ok := dbStructure.Tokens[token]
+	user, ok := dbStructure.Users[id]
 
-	if ok {
-		val.Expires = time.Now().Add(time.Hour * time.Duration(1440))
-		dbStructure.Tokens[token] = val
+	if !ok {
+		return User{}, errors.New("User not found")
+	}
 
-		err2 := db.writeDB(dbStructure)
+	user.AutoExpandSensitive = autoExpandSensitive
+	dbStructure.Users[id] = user
 
-		if err2 != nil {
-			log.Print("Error saving access token")
-			return false, errors.New("error revoking token")
-		}
-		return true, nil
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
 	}
 
-	return false, nil
+	user.Password = nil
+	user.Token = ""
+	user.RefreshToken = ""
+	return user, nil
 }
 
 func (db *DB) UpdatePremium(user int) (bool, error) {
 
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
 	dbStructure, err := db.loadDB()
 
@@ -616,11 +965,33 @@ func (db *DB) UpdatePremium(user int) (bool, error) {
 		return true, nil
 	}
 
-	return false, nil 
+	return false, nil
 }
 
+// passwordCost is the bcrypt cost of stored passwords, tests lower it
+var passwordCost = 14
+
+// dummyPasswordHash is the hash of a random password nobody knows, made at
+// passwordCost on first use. Checking against it costs as much as checking a
+// real one, also when the cost changes.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	password, err := randomHex(16)
+
+	if err != nil {
+		panic("dummy password: " + err.Error())
+	}
+
+	hash, err := HashPassword(password)
+
+	if err != nil {
+		panic("dummy password hash: " + err.Error())
+	}
+
+	return hash
+})
+
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
 	return string(bytes), err
 }
 
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+type Draft struct {
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	Author    int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateDraft saves a new draft for the user
+func (db *DB) CreateDraft(userID int, body string) (Draft, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return Draft{}, err
+	}
+
+	max := 0
+
+	for _, draft := range dbStructure.Drafts {
+		if draft.ID > max {
+			max = draft.ID
+		}
+	}
+
+	now := time.Now().UTC()
+	draft := Draft{
+		ID:        max + 1,
+		Body:      body,
+		Author:    userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	dbStructure.Drafts[draft.ID] = draft
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return Draft{}, err
+	}
+
+	return draft, nil
+}
+
+// GetDrafts returns the drafts of the user, most recently edited first
+func (db *DB) GetDrafts(userID int) ([]Draft, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching drafts in GetDrafts: %v", err)
+		return nil, err
+	}
+
+	drafts := []Draft{}
+
+	for _, draft := range dbStructure.Drafts {
+		if draft.Author == userID {
+			drafts = append(drafts, draft)
+		}
+	}
+
+	slices.SortFunc(drafts, func(a, b Draft) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+
+	return drafts, nil
+}
+
+// GetDraft returns a single draft of the user
+func (db *DB) GetDraft(userID int, id int) (Draft, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching draft in GetDraft: %v", err)
+		return Draft{}, err
+	}
+
+	draft, ok := dbStructure.Drafts[id]
+
+	if !ok || draft.Author != userID {
+		return Draft{}, errors.New("ID not found")
+	}
+
+	return draft, nil
+}
+
+// UpdateDraft replaces the body of a draft of the user
+func (db *DB) UpdateDraft(userID int, id int, body string) (Draft, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return Draft{}, err
+	}
+
+	draft, ok := dbStructure.Drafts[id]
+
+	if !ok || draft.Author != userID {
+		return Draft{}, errors.New("ID not found")
+	}
+
+	draft.Body = body
+	draft.UpdatedAt = time.Now().UTC()
+	dbStructure.Drafts[id] = draft
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return Draft{}, err
+	}
+
+	return draft, nil
+}
+
+// DeleteDraft removes a draft of the user
+func (db *DB) DeleteDraft(userID int, id int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	draft, ok := dbStructure.Drafts[id]
+
+	if !ok || draft.Author != userID {
+		return false, nil
+	}
+
+	delete(dbStructure.Drafts, id)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// PublishDraft turns a draft into a chirp with the given, already validated body
+// and removes the draft in the same write.
+func (db *DB) PublishDraft(userID int, id int, body string, opts ChirpOptions) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return Chirp{}, err
+	}
+
+	draft, ok := dbStructure.Drafts[id]
+
+	if !ok || draft.Author != userID {
+		return Chirp{}, errors.New("ID not found")
+	}
+
+	chirp := insertChirp(&dbStructure, body, userID, opts)
+	delete(dbStructure.Drafts, id)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return Chirp{}, err
+	}
+
+	return chirp.forViewer(dbStructure.Users[userID]), nil
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// limits of RFC 5321 for the path of a mailbox
+const (
+	maxEmailLength    = 254
+	maxEmailLocalPart = 64
+)
+
+var (
+	ErrInvalidEmail        = errors.New("invalid email address")
+	ErrEmailTaken          = errors.New("User already registered")
+	ErrInvalidVerification = errors.New("invalid or expired verification token")
+)
+
+type EmailVerification struct {
+	UserID  int       `json:"user_id"`
+	Email   string    `json:"email"`
+	Expires time.Time `json:"expires"`
+}
+
+// NormaliseEmail checks that email is a bare RFC 5322 address and returns it
+// lower-cased. Display names, comments and quoted local parts are rejected.
+func NormaliseEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+
+	if err != nil || addr.Address != email || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+
+	local := email[:strings.LastIndex(email, "@")]
+
+	if len(local) > maxEmailLocalPart {
+		return "", ErrInvalidEmail
+	}
+
+	return strings.ToLower(email), nil
+}
+
+// emailTaken reports whether another account uses email. Addresses stored
+// before normalisation may differ in case, so they are compared folded.
+func emailTaken(dbStructure DBStructure, email string, except int) bool {
+	for _, user := range dbStructure.Users {
+		if user.ID != except && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateEmailVerification issues a token that proves the user owns email.
+// Only its hash is stored and older tokens of the user are dropped.
+func (db *DB) CreateEmailVerification(userID int, email string, lifetime time.Duration) (string, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return "", err
+	}
+
+	token, err := randomHex(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	for hash, verification := range dbStructure.EmailVerifications {
+		if verification.UserID == userID {
+			delete(dbStructure.EmailVerifications, hash)
+		}
+	}
+
+	dbStructure.EmailVerifications[hashToken(token)] = EmailVerification{
+		UserID:  userID,
+		Email:   email,
+		Expires: time.Now().UTC().Add(lifetime),
+	}
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyEmail redeems a verification token. It either confirms the current
+// address of the user or moves a pending address into place.
+func (db *DB) VerifyEmail(token string) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	hash := hashToken(token)
+	verification, ok := dbStructure.EmailVerifications[hash]
+
+	if !ok || expired(verification.Expires) {
+		return User{}, ErrInvalidVerification
+	}
+
+	user, ok := dbStructure.Users[verification.UserID]
+
+	if !ok {
+		return User{}, ErrInvalidVerification
+	}
+
+	switch verification.Email {
+	case user.Email:
+	case user.PendingEmail:
+		// someone else may have confirmed the address in the meantime
+		if emailTaken(dbStructure, verification.Email, user.ID) {
+			return User{}, ErrEmailTaken
+		}
+
+		user.Email = user.PendingEmail
+		user.PendingEmail = ""
+	default:
+		// the user changed the address again after this token was sent
+		return User{}, ErrInvalidVerification
+	}
+
+	delete(dbStructure.EmailVerifications, hash)
+
+	user.EmailVerified = true
+	dbStructure.Users[user.ID] = user
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
+	}
+
+	user.Password = nil
+	return user, nil
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"math"
+	"slices"
+	"sort"
+)
+
+// Scores follow the "hot" ranking: engagement counts logarithmically and
+// every scoreDecaySeconds of age are worth one order of magnitude of it.
+// Because age is expressed through the creation time, a score only changes
+// when the chirp's own engagement changes and never has to be recomputed
+// for the whole timeline.
+const scoreDecaySeconds = 45000
+
+const (
+	likeWeight    = 1
+	replyWeight   = 2
+	rechirpWeight = 3
+)
+
+func (c *Chirp) updateScore() {
+	engagement := float64(c.Likes*likeWeight + c.Replies*replyWeight + c.Rechirps*rechirpWeight)
+	c.Score = math.Log10(max(engagement, 1)) + float64(c.CreatedAt.Unix())/scoreDecaySeconds
+}
+
+// sortPopular orders chirps by score, newest first on ties
+func sortPopular(chirps []Chirp) {
+	sort.SliceStable(chirps, func(i, j int) bool {
+		if chirps[i].Score != chirps[j].Score {
+			return chirps[i].Score > chirps[j].Score
+		}
+		return chirps[i].ID > chirps[j].ID
+	})
+}
+
+// GetPopularChirps returns a page of the chirps that are not expired, highest
+// score first, prepared for the viewer, 0 for anonymous requests. With
+// hideSensitive set, chirps that need a content warning are left out.
+func (db *DB) GetPopularChirps(limit int, offset int, hideSensitive bool, viewer int) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching chirps in GetPopularChirps: %v", err)
+		return nil, err
+	}
+
+	chirps := []Chirp{}
+	user := dbStructure.Users[viewer]
+
+	for id := range dbStructure.Chirps {
+		if chirp, ok := visibleChirp(dbStructure, id); ok && !(hideSensitive && chirp.needsWarning()) {
+			chirps = append(chirps, chirp.forViewer(user))
+		}
+	}
+
+	sortPopular(chirps)
+
+	return paginate(chirps, limit, offset), nil
+}
+
+// LikeChirp adds or removes the like of a user
+func (db *DB) LikeChirp(userID int, chirpID int, like bool) (Chirp, error) {
+	return db.engage(dbLikes, userID, chirpID, like)
+}
+
+// Rechirp shares or unshares a chirp of another user
+func (db *DB) Rechirp(userID int, chirpID int, rechirp bool) (Chirp, error) {
+	return db.engage(dbRechirps, userID, chirpID, rechirp)
+}
+
+type engagementKind int
+
+const (
+	dbLikes engagementKind = iota
+	dbRechirps
+)
+
+func (db *DB) engage(kind engagementKind, userID int, chirpID int, add bool) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return Chirp{}, err
+	}
+
+	chirp, ok := visibleChirp(dbStructure, chirpID)
+
+	if !ok {
+		return Chirp{}, errors.New("ID not found")
+	}
+
+	users := dbStructure.Likes
+
+	if kind == dbRechirps {
+		if chirp.Author == userID {
+			return Chirp{}, errors.New("can't rechirp your own chirp")
+		}
+
+		users = dbStructure.Rechirps
+	}
+
+	i := slices.Index(users[chirpID], userID)
+
+	switch {
+	case add && i == -1:
+		users[chirpID] = append(users[chirpID], userID)
+	case !add && i != -1:
+		users[chirpID] = slices.Delete(users[chirpID], i, i+1)
+	default:
+		return chirp.forViewer(dbStructure.Users[userID]), nil
+	}
+
+	if kind == dbRechirps {
+		chirp.Rechirps = len(users[chirpID])
+	} else {
+		chirp.Likes = len(users[chirpID])
+	}
+
+	chirp.updateScore()
+	dbStructure.Chirps[chirpID] = chirp
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return Chirp{}, err
+	}
+
+	return chirp.forViewer(dbStructure.Users[userID]), nil
+}
+
+// countReply adds delta to the reply counter of the parent of a chirp
+func countReply(dbStructure DBStructure, chirp Chirp, delta int) {
+	parent, ok := dbStructure.Chirps[chirp.ReplyTo]
+
+	if chirp.ReplyTo == 0 || !ok {
+		return
+	}
+
+	parent.Replies = max(parent.Replies+delta, 0)
+	parent.updateScore()
+	dbStructure.Chirps[parent.ID] = parent
+}
+
+// removeChirp deletes a chirp together with everything that points at it
+func removeChirp(dbStructure DBStructure, chirp Chirp) {
+	delete(dbStructure.Chirps, chirp.ID)
+	delete(dbStructure.Likes, chirp.ID)
+	delete(dbStructure.Rechirps, chirp.ID)
+	unpinChirp(dbStructure, chirp)
+	countReply(dbStructure, chirp, -1)
+
+	for userID, bookmarks := range dbStructure.Bookmarks {
+		dbStructure.Bookmarks[userID] = slices.DeleteFunc(bookmarks, func(id int) bool { return id == chirp.ID })
+	}
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetPopularChirpsPages(t *testing.T) {
+	db, _ := testDB(t)
+
+	for _, email := range []string{"author@example.com", "fan@example.com", "other.fan@example.com"} {
+		loginUser(t, db, email)
+	}
+
+	for _, opts := range []ChirpOptions{{}, {}, {Sensitive: true}, {}, {ContentWarning: "spoilers"}} {
+		if _, err := db.CreateChirp("hello", 1, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// two likes lift the oldest chirp above the newer ones
+	for _, fan := range []int{2, 3} {
+		if _, err := db.LikeChirp(fan, 1, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(limit int, offset int, hideSensitive bool) []int {
+		chirps, err := db.GetPopularChirps(limit, offset, hideSensitive, 0)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []int{}
+
+		for _, chirp := range chirps {
+			ids = append(ids, chirp.ID)
+		}
+
+		return ids
+	}
+
+	tests := []struct {
+		name          string
+		limit, offset int
+		hideSensitive bool
+		want          []int
+	}{
+		{"all", 0, 0, false, []int{1, 5, 4, 3, 2}},
+		{"first page", 2, 0, false, []int{1, 5}},
+		{"second page", 2, 2, false, []int{4, 3}},
+		{"last page", 2, 4, false, []int{2}},
+		{"past the end", 2, 6, false, []int{}},
+		{"sensitive hidden before paging", 2, 2, true, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(tt.limit, tt.offset, tt.hideSensitive); !slices.Equal(got, tt.want) {
+				t.Errorf("GetPopularChirps(%d, %d, %t) = %v, want %v", tt.limit, tt.offset, tt.hideSensitive, got, tt.want)
+			}
+		})
+	}
+}
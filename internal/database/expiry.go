@@ -0,0 +1,42 @@
+package database
+
+import (
+	"log"
+	"time"
+)
+
+// PurgeExpiredChirps deletes every chirp whose lifetime ended before now
+// and drops it from pins and bookmarks.
+func (db *DB) PurgeExpiredChirps(now time.Time) ([]Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return nil, err
+	}
+
+	purged := []Chirp{}
+
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.expiredAt(now) {
+			removeChirp(dbStructure, chirp)
+			purged = append(purged, chirp)
+		}
+	}
+
+	if len(purged) == 0 {
+		return nil, nil
+	}
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return nil, err
+	}
+
+	return purged, nil
+}
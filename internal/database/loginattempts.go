@@ -0,0 +1,104 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// accountFreeAttempts and ipFreeAttempts are the failures allowed before
+	// the backoff starts. An IP may be shared by many users, so it gets more.
+	accountFreeAttempts = 5
+	ipFreeAttempts      = 20
+	// the first lockout lasts loginBackoffBase and every further failure doubles it
+	loginBackoffBase = time.Second
+	maxLoginLockout  = 15 * time.Minute
+	// loginAttemptWindow forgets the failures of a key after a quiet period
+	loginAttemptWindow = time.Hour
+)
+
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// LockedError is returned by Login while an account or IP is locked out
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed logins, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginAttempt counts the failed logins of an account or IP
+type LoginAttempt struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// attemptKeys returns the keys failures are counted under. The account key
+// exists for unknown emails too, so a lockout reveals nothing about them.
+func attemptKeys(email string, ip string) (string, string) {
+	return "account:" + email, "ip:" + ip
+}
+
+// stale reports whether the failures are old enough to be forgotten
+func (a LoginAttempt) stale(now time.Time) bool {
+	return now.Sub(a.LastFailure) > loginAttemptWindow && !now.Before(a.LockedUntil)
+}
+
+// lockout returns how long the longest lock on any of keys still lasts
+func lockout(dbStructure DBStructure, now time.Time, keys ...string) time.Duration {
+	var longest time.Duration
+
+	for _, key := range keys {
+		if wait := dbStructure.LoginAttempts[key].LockedUntil.Sub(now); wait > longest {
+			longest = wait
+		}
+	}
+
+	return longest
+}
+
+// recordFailure counts a failed login and locks the key once it used up free attempts
+func recordFailure(dbStructure DBStructure, key string, free int, now time.Time) {
+	attempt := dbStructure.LoginAttempts[key]
+
+	if attempt.stale(now) {
+		attempt = LoginAttempt{}
+	}
+
+	attempt.Failures++
+	attempt.LastFailure = now
+
+	if over := attempt.Failures - free; over > 0 {
+		wait := maxLoginLockout
+
+		// the shift overflows long before it matters
+		if over <= 20 {
+			wait = min(loginBackoffBase<<(over-1), maxLoginLockout)
+		}
+
+		attempt.LockedUntil = now.Add(wait)
+	}
+
+	dbStructure.LoginAttempts[key] = attempt
+}
+
+// refundFailure takes back the failure recordFailure counted for an attempt
+// that succeeded after all
+func refundFailure(dbStructure DBStructure, key string, free int) {
+	attempt, ok := dbStructure.LoginAttempts[key]
+
+	if !ok {
+		return
+	}
+
+	attempt.Failures = max(attempt.Failures-1, 0)
+
+	if attempt.Failures <= free {
+		attempt.LockedUntil = time.Time{}
+	}
+
+	dbStructure.LoginAttempts[key] = attempt
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+	"github.com/nilsboi/Chirpy/internal/passwords"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "a long enough passphrase"
+
+// loginUser creates a user without a second factor
+func loginUser(t *testing.T, db *DB, email string) {
+	t.Helper()
+
+	if _, err := db.CreateUser(email, testPassword, passwords.DefaultPolicy); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginLocksOutAccount(t *testing.T) {
+	db, keys := testDB(t)
+	loginUser(t, db, "victim@example.com")
+
+	login := func(ip string, password string, now time.Time) error {
+		_, err := db.Login("victim@example.com", password, keys, auth.DefaultPolicy, Client{IP: ip}, 0, now)
+		return err
+	}
+
+	for i := range accountFreeAttempts {
+		if err := login(fmt.Sprintf("192.0.2.%d", i), "wrong", testNow); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failure %d: got %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+
+	// every failure after the free ones locks the account twice as long,
+	// each from a new IP so only the account lock counts
+	now := testNow
+	tests := []struct {
+		name string
+		wait time.Duration
+	}{
+		{"6th failure", loginBackoffBase},
+		{"7th failure", 2 * loginBackoffBase},
+		{"8th failure", 4 * loginBackoffBase},
+		{"9th failure", 8 * loginBackoffBase},
+	}
+
+	for i, tt := range tests {
+		locked := &LockedError{}
+
+		if err := login(fmt.Sprintf("198.51.100.%d", i), "wrong", now); !errors.As(err, &locked) || locked.RetryAfter != tt.wait {
+			t.Fatalf("%s: got %v, want a lock of %s", tt.name, err, tt.wait)
+		}
+
+		// attempts during the lock don't even reach the password check
+		if err := login("203.0.113.1", testPassword, now.Add(tt.wait-time.Millisecond)); !errors.As(err, &locked) {
+			t.Fatalf("%s: right password during the lock: got %v, want a lock", tt.name, err)
+		}
+
+		now = now.Add(tt.wait)
+	}
+
+	if err := login("203.0.113.1", testPassword, now); err != nil {
+		t.Errorf("right password after the lock: %v", err)
+	}
+}
+
+func TestLoginLocksOutIPAcrossEmails(t *testing.T) {
+	db, keys := testDB(t)
+	const ip = "192.0.2.1"
+
+	for i := range ipFreeAttempts + 1 {
+		loginUser(t, db, fmt.Sprintf("user%d@example.com", i))
+	}
+
+	for i := range ipFreeAttempts {
+		_, err := db.Login(fmt.Sprintf("user%d@example.com", i), "wrong", keys, auth.DefaultPolicy, Client{IP: ip}, 0, testNow)
+
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failure %d: got %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+
+	locked := &LockedError{}
+	last := fmt.Sprintf("user%d@example.com", ipFreeAttempts)
+
+	if _, err := db.Login(last, "wrong", keys, auth.DefaultPolicy, Client{IP: ip}, 0, testNow); !errors.As(err, &locked) {
+		t.Fatalf("failure %d from one IP: got %v, want a lock", ipFreeAttempts+1, err)
+	}
+
+	if _, err := db.Login(last, testPassword, keys, auth.DefaultPolicy, Client{IP: ip}, 0, testNow); !errors.As(err, &locked) {
+		t.Errorf("right password from the locked IP: got %v, want a lock", err)
+	}
+
+	if _, err := db.Login(last, testPassword, keys, auth.DefaultPolicy, Client{IP: "192.0.2.2"}, 0, testNow); err != nil {
+		t.Errorf("right password from another IP: %v", err)
+	}
+}
+
+func TestLoginRefundsIPFailure(t *testing.T) {
+	db, keys := testDB(t)
+	loginUser(t, db, "user@example.com")
+	client := Client{IP: "192.0.2.1"}
+
+	for range 3 {
+		if _, err := db.Login("user@example.com", "wrong", keys, auth.DefaultPolicy, client, 0, testNow); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.Login("user@example.com", testPassword, keys, auth.DefaultPolicy, client, 0, testNow); err != nil {
+		t.Fatal(err)
+	}
+
+	dbStructure, _ := db.loadDB()
+	accountKey, ipKey := attemptKeys("user@example.com", client.IP)
+
+	if failures := dbStructure.LoginAttempts[ipKey].Failures; failures != 3 {
+		t.Errorf("IP failures = %d, want the 3 wrong passwords only", failures)
+	}
+
+	if _, ok := dbStructure.LoginAttempts[accountKey]; ok {
+		t.Error("account failures left after a successful login")
+	}
+}
+
+func TestLoginUnknownEmail(t *testing.T) {
+	db, keys := testDB(t)
+	loginUser(t, db, "known@example.com")
+
+	_, wrongPassword := db.Login("known@example.com", "wrong", keys, auth.DefaultPolicy, Client{}, 0, testNow)
+	_, unknownEmail := db.Login("unknown@example.com", testPassword, keys, auth.DefaultPolicy, Client{}, 0, testNow)
+
+	if !errors.Is(wrongPassword, ErrInvalidCredentials) || wrongPassword != unknownEmail {
+		t.Errorf("errors differ: wrong password %v, unknown email %v", wrongPassword, unknownEmail)
+	}
+
+	// the unknown email is checked against a hash as slow as a real one
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash()))
+
+	if err != nil || cost != passwordCost {
+		t.Errorf("dummy hash cost = %d, %v, want %d", cost, err, passwordCost)
+	}
+
+	dbStructure, _ := db.loadDB()
+	accountKey, _ := attemptKeys("unknown@example.com", "")
+
+	if failures := dbStructure.LoginAttempts[accountKey].Failures; failures != 1 {
+		t.Errorf("unknown email failures = %d, want 1, like any other account", failures)
+	}
+}
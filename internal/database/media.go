@@ -0,0 +1,81 @@
+package database
+
+import (
+	"errors"
+	"log"
+)
+
+const maxMediaPerChirp = 4
+
+type Media struct {
+	Hash         string `json:"hash"`
+	MimeType     string `json:"mime_type"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// CanAttachMedia checks that the user may add another file to the chirp, so
+// uploads are refused before anything is stored
+func (db *DB) CanAttachMedia(userID int, chirpID int) error {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return err
+	}
+
+	_, err = attachable(dbStructure, userID, chirpID)
+
+	return err
+}
+
+// attachable returns the chirp if it belongs to the user and has room for a file
+func attachable(dbStructure DBStructure, userID int, chirpID int) (Chirp, error) {
+	chirp, ok := visibleChirp(dbStructure, chirpID)
+
+	if !ok || chirp.Author != userID {
+		return Chirp{}, errors.New("ID not found")
+	}
+
+	if len(chirp.Media) >= maxMediaPerChirp {
+		return Chirp{}, errors.New("too many attachments")
+	}
+
+	return chirp, nil
+}
+
+// AttachMedia adds an uploaded file to one of the user's own chirps
+func (db *DB) AttachMedia(userID int, chirpID int, media Media) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return Chirp{}, err
+	}
+
+	chirp, err := attachable(dbStructure, userID, chirpID)
+
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.Media = append(chirp.Media, media)
+	dbStructure.Chirps[chirpID] = chirp
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return Chirp{}, err
+	}
+
+	return chirp.forViewer(dbStructure.Users[userID]), nil
+}
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+	"github.com/nilsboi/Chirpy/internal/totp"
+)
+
+const (
+	mfaChallengeLifetime = 5 * time.Minute
+	// mfaEnrolmentLifetime is how long a pending secret waits for its first code
+	mfaEnrolmentLifetime = 15 * time.Minute
+	maxMFAAttempts       = 5
+	// maxMFAChallenges caps the live challenges of a user, older ones are dropped
+	maxMFAChallenges  = 3
+	recoveryCodeCount = 10
+	// totpSkew accepts the codes of the neighbouring time steps for clock drift
+	totpSkew = 1
+)
+
+var (
+	ErrMFAEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFA    = errors.New("invalid or expired MFA challenge")
+	ErrInvalidCode   = errors.New("invalid code")
+	ErrMFAEnrolment  = errors.New("no pending two-factor enrolment, start a new one")
+)
+
+// MFA holds the second factor of a user. It lives outside User so the secret
+// never ends up in a response.
+type MFA struct {
+	Secret string `json:"secret,omitempty"`
+	// PendingSecret waits for a first valid code before it replaces Secret
+	PendingSecret string `json:"pending_secret,omitempty"`
+	// PendingExpires ends an enrolment that was never confirmed
+	PendingExpires time.Time `json:"pending_expires,omitempty"`
+	// LastStep is the time step of the last accepted code, older codes are replays
+	LastStep int64 `json:"last_step,omitempty"`
+	// RecoveryCodes are SHA-256 hashes, every code works once
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// MFAChallenge remembers a login that passed the password check
+type MFAChallenge struct {
+	UserID    int       `json:"user_id"`
+	Expires   time.Time `json:"expires"`
+	Attempts  int       `json:"attempts"`
+	ExpiresIn int       `json:"expires_in,omitempty"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	Type      string    `json:"client_type"`
+}
+
+// createMFAChallenge stores everything the second login step needs to start the session
+func createMFAChallenge(dbStructure DBStructure, userID int, client Client, expiresIn time.Duration, now time.Time) (string, error) {
+	token, err := randomHex(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	dropOldChallenges(dbStructure, userID, maxMFAChallenges-1)
+
+	dbStructure.MFAChallenges[hashToken(token)] = MFAChallenge{
+		UserID:    userID,
+		Expires:   now.Add(mfaChallengeLifetime),
+		ExpiresIn: int(expiresIn.Seconds()),
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		Type:      client.Type,
+	}
+
+	return token, nil
+}
+
+// dropOldChallenges deletes the challenges of a user that expire first until
+// at most keep are left
+func dropOldChallenges(dbStructure DBStructure, userID int, keep int) {
+	hashes := []string{}
+
+	for hash, challenge := range dbStructure.MFAChallenges {
+		if challenge.UserID == userID {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	if len(hashes) <= keep {
+		return
+	}
+
+	slices.SortFunc(hashes, func(a, b string) int {
+		return dbStructure.MFAChallenges[a].Expires.Compare(dbStructure.MFAChallenges[b].Expires)
+	})
+
+	for _, hash := range hashes[:len(hashes)-keep] {
+		delete(dbStructure.MFAChallenges, hash)
+	}
+}
+
+// generateRecoveryCodes returns codes like "3f9a1-c07be" and the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		random, err := randomHex(5)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := random[:5] + "-" + random[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// verifySecondFactor accepts a TOTP code that wasn't used before or an unused
+// recovery code. It updates mfa, the caller has to store it.
+func verifySecondFactor(mfa *MFA, code string, now time.Time) bool {
+	if step, ok := totp.Validate(mfa.Secret, code, now, totpSkew); ok && step > mfa.LastStep {
+		mfa.LastStep = step
+		return true
+	}
+
+	hash := hashToken(strings.ToLower(strings.TrimSpace(code)))
+	i := slices.Index(mfa.RecoveryCodes, hash)
+
+	if i == -1 {
+		return false
+	}
+
+	mfa.RecoveryCodes = slices.Delete(mfa.RecoveryCodes, i, i+1)
+	return true
+}
+
+// EnrollTOTP creates a new secret for the user. It only becomes active once
+// ConfirmTOTP sees a valid code for it before mfaEnrolmentLifetime is over.
+func (db *DB) EnrollTOTP(userID int, now time.Time) (string, User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return "", User{}, err
+	}
+
+	user, ok := dbStructure.Users[userID]
+
+	if !ok {
+		return "", User{}, errors.New("User not found")
+	}
+
+	if user.MFAEnabled {
+		return "", User{}, ErrMFAEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		return "", User{}, err
+	}
+
+	dbStructure.MFA[userID] = MFA{PendingSecret: secret, PendingExpires: now.Add(mfaEnrolmentLifetime)}
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return "", User{}, err
+	}
+
+	user.Password = nil
+	return secret, user, nil
+}
+
+// ConfirmTOTP enables two-factor authentication with the pending secret and
+// returns the recovery codes. They are only stored hashed, so this is the
+// only time the user sees them.
+func (db *DB) ConfirmTOTP(userID int, code string, now time.Time) ([]string, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return nil, err
+	}
+
+	user, ok := dbStructure.Users[userID]
+	mfa := dbStructure.MFA[userID]
+
+	if !ok || user.MFAEnabled {
+		return nil, ErrMFAEnabled
+	}
+
+	if mfa.PendingSecret == "" || expiredAt(mfa.PendingExpires, now) {
+		return nil, ErrMFAEnrolment
+	}
+
+	step, ok := totp.Validate(mfa.PendingSecret, code, now, totpSkew)
+
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	dbStructure.MFA[userID] = MFA{
+		Secret:        mfa.PendingSecret,
+		LastStep:      step,
+		RecoveryCodes: hashes,
+	}
+
+	user.MFAEnabled = true
+	dbStructure.Users[userID] = user
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off after checking a current code
+func (db *DB) DisableTOTP(userID int, code string, now time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return err
+	}
+
+	user, ok := dbStructure.Users[userID]
+	mfa := dbStructure.MFA[userID]
+
+	if !ok || !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+
+	if !verifySecondFactor(&mfa, code, now) {
+		return ErrInvalidCode
+	}
+
+	delete(dbStructure.MFA, userID)
+	user.MFAEnabled = false
+	dbStructure.Users[userID] = user
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// CompleteMFALogin redeems an MFA challenge from Login with a TOTP or
+// recovery code and starts the session. A challenge allows a few attempts and
+// every wrong code counts against the lockout of the account, which is only
+// cleared once a code is right.
+func (db *DB) CompleteMFALogin(mfaToken string, code string, keys *auth.Keyring, policy auth.Policy, now time.Time) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	hash := hashToken(mfaToken)
+	challenge, ok := dbStructure.MFAChallenges[hash]
+
+	if !ok || expiredAt(challenge.Expires, now) {
+		return User{}, ErrInvalidMFA
+	}
+
+	user, ok := dbStructure.Users[challenge.UserID]
+	mfa := dbStructure.MFA[challenge.UserID]
+
+	if !ok || !user.MFAEnabled {
+		return User{}, ErrInvalidMFA
+	}
+
+	accountKey, _ := attemptKeys(strings.ToLower(user.Email), challenge.IP)
+
+	if wait := lockout(dbStructure, now, accountKey); wait > 0 {
+		return User{}, &LockedError{RetryAfter: wait}
+	}
+
+	if !verifySecondFactor(&mfa, code, now) {
+		recordFailure(dbStructure, accountKey, accountFreeAttempts, now)
+		challenge.Attempts++
+
+		if challenge.Attempts >= maxMFAAttempts {
+			delete(dbStructure.MFAChallenges, hash)
+		} else {
+			dbStructure.MFAChallenges[hash] = challenge
+		}
+
+		if err := db.writeDB(dbStructure); err != nil {
+			log.Printf("Error writing database file: %v", err)
+			return User{}, err
+		}
+
+		return User{}, ErrInvalidCode
+	}
+
+	delete(dbStructure.MFAChallenges, hash)
+	delete(dbStructure.LoginAttempts, accountKey)
+	dbStructure.MFA[user.ID] = mfa
+
+	client := Client{UserAgent: challenge.UserAgent, IP: challenge.IP, Type: challenge.Type}
+	user, err = startSession(dbStructure, user, keys, policy, client, time.Duration(challenge.ExpiresIn)*time.Second, now)
+
+	if err != nil {
+		return User{}, err
+	}
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
+	}
+
+	user.Password = nil
+	return user, nil
+}
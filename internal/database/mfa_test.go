@@ -0,0 +1,321 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+	"github.com/nilsboi/Chirpy/internal/passwords"
+	"github.com/nilsboi/Chirpy/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	// the cost only slows hashing down, the tests don't depend on it
+	passwordCost = bcrypt.MinCost
+	os.Exit(m.Run())
+}
+
+const testSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// testNow is far from the wall clock, so anything that reads it instead of
+// the now passed in shows up as an expired or never-expiring timestamp
+var testNow = time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+func mustCode(t *testing.T, at time.Time) string {
+	t.Helper()
+
+	code, err := totp.Code(testSecret, at)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func TestVerifySecondFactorTOTP(t *testing.T) {
+	now := time.Unix(1_700_000_010, 0)
+
+	tests := []struct {
+		name     string
+		lastStep int64
+		code     string
+		at       time.Time
+		ok       bool
+	}{
+		{"current code", 0, mustCode(t, now), now, true},
+		{"code of the previous step", 0, mustCode(t, now.Add(-totp.Period)), now, true},
+		{"code from two steps ago", 0, mustCode(t, now.Add(-2*totp.Period)), now, false},
+		{"replayed code", totp.Step(now), mustCode(t, now), now, false},
+		{"code older than the last one used", totp.Step(now), mustCode(t, now.Add(-totp.Period)), now, false},
+		{"wrong code", 0, "000000", now, mustCode(t, now) == "000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mfa := MFA{Secret: testSecret, LastStep: tt.lastStep}
+
+			if got := verifySecondFactor(&mfa, tt.code, tt.at); got != tt.ok {
+				t.Fatalf("verifySecondFactor = %t, want %t", got, tt.ok)
+			}
+
+			if tt.ok && mfa.LastStep <= tt.lastStep {
+				t.Errorf("LastStep = %d, want it to move past %d", mfa.LastStep, tt.lastStep)
+			}
+		})
+	}
+}
+
+func TestVerifySecondFactorRecoveryCodes(t *testing.T) {
+	now := time.Unix(1_700_000_010, 0)
+	codes, hashes, err := generateRecoveryCodes()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	mfa := MFA{Secret: testSecret, RecoveryCodes: hashes}
+
+	tests := []struct {
+		name string
+		code string
+		ok   bool
+		left int
+	}{
+		{"first code", codes[0], true, recoveryCodeCount - 1},
+		{"same code again", codes[0], false, recoveryCodeCount - 1},
+		{"upper case with spaces", "  " + strings.ToUpper(codes[1]) + " ", true, recoveryCodeCount - 2},
+		{"unknown code", "00000-00000", false, recoveryCodeCount - 2},
+		{"last code", codes[recoveryCodeCount-1], true, recoveryCodeCount - 3},
+	}
+
+	for _, tt := range tests {
+		if got := verifySecondFactor(&mfa, tt.code, now); got != tt.ok {
+			t.Errorf("%s: verifySecondFactor = %t, want %t", tt.name, got, tt.ok)
+		}
+
+		if len(mfa.RecoveryCodes) != tt.left {
+			t.Errorf("%s: %d codes left, want %d", tt.name, len(mfa.RecoveryCodes), tt.left)
+		}
+	}
+}
+
+// testDB opens a fresh database with a keyring to sign tokens
+func testDB(t *testing.T) (*DB, *auth.Keyring) {
+	t.Helper()
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := auth.LoadKeyring(t.TempDir(), time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, keys
+}
+
+// mfaUser creates a user with TOTP enabled at testNow on a fresh database
+func mfaUser(t *testing.T) (*DB, *auth.Keyring, string, string) {
+	t.Helper()
+
+	db, keys := testDB(t)
+
+	const email, password = "mfa@example.com", "a long enough passphrase"
+	user, err := db.CreateUser(email, password, passwords.DefaultPolicy)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, _, err := db.EnrollTOTP(user.ID, testNow)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := totp.Code(secret, testNow)
+
+	if _, err := db.ConfirmTOTP(user.ID, code, testNow); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, keys, email, password
+}
+
+func TestCompleteMFALoginLocksOutGuessing(t *testing.T) {
+	db, keys, email, password := mfaUser(t)
+	locked := &LockedError{}
+
+	// a known password must not buy more code guesses than the lockout allows
+	guesses := 0
+
+	for range 3 * maxMFAAttempts {
+		user, err := db.Login(email, password, keys, auth.DefaultPolicy, Client{IP: "192.0.2.1"}, 0, testNow)
+
+		if errors.As(err, &locked) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for range maxMFAAttempts {
+			_, err = db.CompleteMFALogin(user.MFAToken, "xxxxxx", keys, auth.DefaultPolicy, testNow)
+
+			if errors.As(err, &locked) {
+				break
+			}
+
+			if !errors.Is(err, ErrInvalidCode) {
+				t.Fatalf("wrong code: got %v, want ErrInvalidCode", err)
+			}
+
+			guesses++
+		}
+	}
+
+	if guesses > accountFreeAttempts {
+		t.Errorf("%d wrong codes were checked, want at most %d", guesses, accountFreeAttempts)
+	}
+
+	_, err := db.Login(email, password, keys, auth.DefaultPolicy, Client{IP: "192.0.2.2"}, 0, testNow)
+
+	if !errors.As(err, &locked) {
+		t.Errorf("login after guessing: got %v, want a lockout", err)
+	}
+}
+
+func TestMFAChallengesAreCapped(t *testing.T) {
+	db, keys, email, password := mfaUser(t)
+
+	for range maxMFAChallenges + 1 {
+		if _, err := db.Login(email, password, keys, auth.DefaultPolicy, Client{}, 0, testNow); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(dbStructure.MFAChallenges); n != maxMFAChallenges {
+		t.Errorf("%d live challenges, want %d", n, maxMFAChallenges)
+	}
+
+	// the password step alone doesn't clear the failures of the account
+	accountKey, _ := attemptKeys(email, "")
+
+	if failures := dbStructure.LoginAttempts[accountKey].Failures; failures != maxMFAChallenges+1 {
+		t.Errorf("account failures = %d, want %d", failures, maxMFAChallenges+1)
+	}
+}
+
+func TestCompleteMFALoginClearsFailures(t *testing.T) {
+	db, keys, email, password := mfaUser(t)
+
+	user, err := db.Login(email, password, keys, auth.DefaultPolicy, Client{}, 0, testNow)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the code of the next step, the one from enrolment was used already
+	now := testNow.Add(totp.Period)
+	dbStructure, _ := db.loadDB()
+	code, _ := totp.Code(dbStructure.MFA[1].Secret, now)
+
+	user, err = db.CompleteMFALogin(user.MFAToken, code, keys, auth.DefaultPolicy, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Token == "" {
+		t.Error("no access token after the second factor")
+	}
+
+	dbStructure, _ = db.loadDB()
+	accountKey, _ := attemptKeys(email, "")
+
+	if _, ok := dbStructure.LoginAttempts[accountKey]; ok {
+		t.Error("account failures left after a successful second factor")
+	}
+}
+
+func TestMFAChallengeExpiresOnTheGivenClock(t *testing.T) {
+	tests := []struct {
+		name  string
+		after time.Duration
+		ok    bool
+	}{
+		{"within the lifetime", totp.Period, true},
+		{"at the last instant", mfaChallengeLifetime, true},
+		{"after the lifetime", mfaChallengeLifetime + time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, keys, email, password := mfaUser(t)
+
+			user, err := db.Login(email, password, keys, auth.DefaultPolicy, Client{}, 0, testNow)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			now := testNow.Add(tt.after)
+			dbStructure, _ := db.loadDB()
+			code, _ := totp.Code(dbStructure.MFA[1].Secret, now)
+
+			_, err = db.CompleteMFALogin(user.MFAToken, code, keys, auth.DefaultPolicy, now)
+
+			if tt.ok && err != nil {
+				t.Errorf("second factor after %s: %v", tt.after, err)
+			}
+
+			if !tt.ok && !errors.Is(err, ErrInvalidMFA) {
+				t.Errorf("second factor after %s: got %v, want ErrInvalidMFA", tt.after, err)
+			}
+		})
+	}
+}
+
+func TestPendingEnrolmentExpires(t *testing.T) {
+	db, _ := testDB(t)
+
+	user, err := db.CreateUser("late@example.com", "a long enough passphrase", passwords.DefaultPolicy)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, _, err := db.EnrollTOTP(user.ID, testNow)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	late := testNow.Add(mfaEnrolmentLifetime + time.Minute)
+	code, _ := totp.Code(secret, late)
+
+	if _, err := db.ConfirmTOTP(user.ID, code, late); !errors.Is(err, ErrMFAEnrolment) {
+		t.Errorf("confirming a stale enrolment: got %v, want ErrMFAEnrolment", err)
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"slices"
+)
+
+// GetFlaggedChirps returns the chirps the content filter flagged for review, oldest first
+func (db *DB) GetFlaggedChirps(limit int, offset int) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching flagged chirps: %v", err)
+		return nil, err
+	}
+
+	chirps := []Chirp{}
+
+	for id, chirp := range dbStructure.Chirps {
+		if !chirp.Flagged {
+			continue
+		}
+
+		// moderators see the chirp the way its author does
+		if chirp, ok := visibleChirp(dbStructure, id); ok {
+			chirps = append(chirps, chirp.forViewer(dbStructure.Users[chirp.Author]))
+		}
+	}
+
+	slices.SortFunc(chirps, func(a, b Chirp) int { return a.ID - b.ID })
+
+	return paginate(chirps, limit, offset), nil
+}
+
+// ApproveChirp clears the review flag of a chirp
+func (db *DB) ApproveChirp(chirpID int) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return Chirp{}, err
+	}
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+
+	if !ok {
+		return Chirp{}, errors.New("ID not found")
+	}
+
+	chirp.Flagged = false
+	dbStructure.Chirps[chirpID] = chirp
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return Chirp{}, err
+	}
+
+	return chirp.forViewer(dbStructure.Users[chirp.Author]), nil
+}
+
+// RemoveChirp deletes a chirp of any author on behalf of a moderator
+func (db *DB) RemoveChirp(chirpID int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+
+	if !ok {
+		return false, nil
+	}
+
+	removeChirp(dbStructure, chirp)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
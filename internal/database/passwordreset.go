@@ -0,0 +1,159 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/passwords"
+)
+
+var (
+	ErrUnknownEmail = errors.New("no account with this email")
+	ErrInvalidReset = errors.New("invalid or expired reset token")
+)
+
+type PasswordReset struct {
+	UserID  int       `json:"user_id"`
+	Expires time.Time `json:"expires"`
+}
+
+// CreatePasswordReset issues a reset token for the account of email. Only
+// the hash of the token is stored and older reset tokens of the user are dropped.
+func (db *DB) CreatePasswordReset(email string, lifetime time.Duration) (string, User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return "", User{}, err
+	}
+
+	var user User
+	found := false
+
+	for _, u := range dbStructure.Users {
+		if strings.EqualFold(u.Email, strings.TrimSpace(email)) {
+			user = u
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return "", User{}, ErrUnknownEmail
+	}
+
+	token, err := randomHex(32)
+
+	if err != nil {
+		return "", User{}, err
+	}
+
+	for hash, reset := range dbStructure.PasswordResets {
+		if reset.UserID == user.ID {
+			delete(dbStructure.PasswordResets, hash)
+		}
+	}
+
+	dbStructure.PasswordResets[hashToken(token)] = PasswordReset{
+		UserID:  user.ID,
+		Expires: time.Now().UTC().Add(lifetime),
+	}
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return "", User{}, err
+	}
+
+	user.Password = nil
+	return token, user, nil
+}
+
+// ResetPassword redeems a reset token, sets the new password and logs the
+// user out everywhere. The token can only be used once and the password has
+// to pass policy.
+func (db *DB) ResetPassword(token string, password string, policy passwords.Policy) (User, error) {
+	hash := hashToken(token)
+
+	// bcrypt is slow, so bad tokens are turned away before hashing
+	// and the token is checked again once the lock is held
+	db.mux.RLock()
+	dbStructure, err := db.loadDB()
+	db.mux.RUnlock()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	user, ok := validReset(dbStructure, hash)
+
+	if !ok {
+		return User{}, ErrInvalidReset
+	}
+
+	err = policy.Check(password, user.Email)
+
+	if err != nil {
+		return User{}, err
+	}
+
+	hashed, err := HashPassword(password)
+
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		return User{}, err
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err = db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	user, ok = validReset(dbStructure, hash)
+
+	if !ok {
+		return User{}, ErrInvalidReset
+	}
+
+	delete(dbStructure.PasswordResets, hash)
+
+	user.Password = &hashed
+	dbStructure.Users[user.ID] = user
+
+	revokeAllSessions(dbStructure, user.ID, time.Now().UTC())
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
+	}
+
+	user.Password = nil
+	return user, nil
+}
+
+// validReset returns the user of an unexpired reset token
+func validReset(dbStructure DBStructure, hash string) (User, bool) {
+	reset, ok := dbStructure.PasswordResets[hash]
+
+	if !ok || expired(reset.Expires) {
+		return User{}, false
+	}
+
+	user, ok := dbStructure.Users[reset.UserID]
+
+	return user, ok
+}
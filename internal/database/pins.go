@@ -0,0 +1,107 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"slices"
+)
+
+const (
+	maxPinnedChirps        = 3
+	maxPinnedChirpsPremium = 10
+)
+
+// PinChirp pins one of the user's own chirps to their profile
+func (db *DB) PinChirp(userID int, chirpID int) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	user, ok := dbStructure.Users[userID]
+
+	if !ok {
+		return User{}, errors.New("user not found")
+	}
+
+	chirp, ok := visibleChirp(dbStructure, chirpID)
+
+	if !ok || chirp.Author != userID {
+		return User{}, errors.New("ID not found")
+	}
+
+	if !slices.Contains(user.Pinned, chirpID) {
+		limit := maxPinnedChirps
+
+		if user.Premium {
+			limit = maxPinnedChirpsPremium
+		}
+
+		if len(user.Pinned) >= limit {
+			return User{}, errors.New("too many pinned chirps")
+		}
+
+		user.Pinned = append(user.Pinned, chirpID)
+	}
+
+	dbStructure.Users[userID] = user
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
+	}
+
+	user.Password = nil
+	user.Token = ""
+	user.RefreshToken = ""
+	return user, nil
+}
+
+// UnpinChirp removes a chirp from the pinned chirps of the user
+func (db *DB) UnpinChirp(userID int, chirpID int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+
+	if !ok || chirp.Author != userID || !slices.Contains(dbStructure.Users[userID].Pinned, chirpID) {
+		return false, nil
+	}
+
+	unpinChirp(dbStructure, chirp)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// unpinChirp drops the chirp from its author's pinned chirps
+func unpinChirp(dbStructure DBStructure, chirp Chirp) {
+	user, ok := dbStructure.Users[chirp.Author]
+
+	if !ok {
+		return
+	}
+
+	user.Pinned = slices.DeleteFunc(user.Pinned, func(id int) bool { return id == chirp.ID })
+	dbStructure.Users[chirp.Author] = user
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"strings"
+	"time"
+)
+
+const (
+	minPollOptions = 2
+	maxPollOptions = 4
+)
+
+type Poll struct {
+	Options  []PollOption `json:"options"`
+	ClosesAt time.Time    `json:"closes_at"`
+	// Votes maps voter ids to the chosen option and is never sent to clients
+	Votes          map[int]int `json:"votes,omitempty"`
+	Voted          *int        `json:"voted,omitempty"`
+	ResultsVisible bool        `json:"results_visible"`
+}
+
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+// NewPoll validates the options and closing time of a poll
+func NewPoll(options []string, closesAt time.Time) (*Poll, error) {
+	if len(options) < minPollOptions || len(options) > maxPollOptions {
+		return nil, errors.New("a poll needs between 2 and 4 options")
+	}
+
+	if !closesAt.After(time.Now()) {
+		return nil, errors.New("closes_at must be in the future")
+	}
+
+	poll := Poll{
+		Options:  make([]PollOption, 0, len(options)),
+		ClosesAt: closesAt.UTC(),
+		Votes:    map[int]int{},
+	}
+
+	for _, option := range options {
+		option = strings.TrimSpace(option)
+
+		if option == "" {
+			return nil, errors.New("poll options must not be empty")
+		}
+
+		poll.Options = append(poll.Options, PollOption{Text: option})
+	}
+
+	return &poll, nil
+}
+
+func (p *Poll) closed() bool {
+	return expired(p.ClosesAt)
+}
+
+// Vote records the vote of a user on the poll of a chirp.
+// The poll lives inside the chirp, so the vote is stored with a single write.
+func (db *DB) Vote(chirpID int, userID int, option int) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return Chirp{}, err
+	}
+
+	chirp, ok := visibleChirp(dbStructure, chirpID)
+
+	if !ok || chirp.Poll == nil {
+		return Chirp{}, errors.New("poll not found")
+	}
+
+	poll := *chirp.Poll
+
+	if chirp.Author == userID {
+		return Chirp{}, errors.New("authors can't vote on their own poll")
+	}
+
+	if poll.closed() {
+		return Chirp{}, errors.New("poll is closed")
+	}
+
+	if _, ok := poll.Votes[userID]; ok {
+		return Chirp{}, errors.New("already voted")
+	}
+
+	if option < 0 || option >= len(poll.Options) {
+		return Chirp{}, errors.New("invalid option")
+	}
+
+	poll.Options = append([]PollOption{}, poll.Options...)
+	poll.Options[option].Votes++
+	poll.Votes = maps.Clone(poll.Votes)
+
+	if poll.Votes == nil {
+		poll.Votes = map[int]int{}
+	}
+
+	poll.Votes[userID] = option
+	chirp.Poll = &poll
+	dbStructure.Chirps[chirpID] = chirp
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return Chirp{}, err
+	}
+
+	return chirp.forViewer(dbStructure.Users[userID]), nil
+}
+
+// forViewer hides the voters and, until the viewer voted or the poll
+// closed, the results. A viewer with id 0 is an anonymous request.
+func (p Poll) forViewer(viewer int) *Poll {
+	option, voted := p.Votes[viewer]
+
+	p.Votes = nil
+	p.ResultsVisible = (viewer != 0 && voted) || p.closed()
+
+	if voted && viewer != 0 {
+		p.Voted = &option
+	}
+
+	if !p.ResultsVisible {
+		options := make([]PollOption, len(p.Options))
+
+		for i, o := range p.Options {
+			options[i] = PollOption{Text: o.Text}
+		}
+
+		p.Options = options
+	}
+
+	return &p
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxDisplayNameLength = 50
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_]{3,20}$`)
+
+// UpdateProfile sets the public username and display name of a user.
+// Usernames are stored lowercase and have to be unique.
+func (db *DB) UpdateProfile(id int, username string, displayName string) (User, error) {
+	username = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+	displayName = strings.TrimSpace(displayName)
+
+	if !usernamePattern.MatchString(username) {
+		return User{}, errors.New("username must be 3-20 characters of a-z, 0-9 and _")
+	}
+
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return User{}, errors.New("display name is too long")
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	user, ok := dbStructure.Users[id]
+
+	if !ok {
+		return User{}, errors.New("User not found")
+	}
+
+	for _, other := range dbStructure.Users {
+		if other.ID != id && other.Username == username {
+			return User{}, errors.New("username already taken")
+		}
+	}
+
+	user.Username = username
+	user.DisplayName = displayName
+	dbStructure.Users[id] = user
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
+	}
+
+	user.Password = nil
+	user.Token = ""
+	user.RefreshToken = ""
+	return user, nil
+}
+
+// BlockUser stops blocked from finding blocker in user search
+func (db *DB) BlockUser(blocker int, blocked int) error {
+	if blocker == blocked {
+		return errors.New("can't block yourself")
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return err
+	}
+
+	if _, ok := dbStructure.Users[blocked]; !ok {
+		return errors.New("User not found")
+	}
+
+	if slices.Contains(dbStructure.Blocks[blocker], blocked) {
+		return nil
+	}
+
+	dbStructure.Blocks[blocker] = append(dbStructure.Blocks[blocker], blocked)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// UnblockUser lifts a block
+func (db *DB) UnblockUser(blocker int, blocked int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	blocks := dbStructure.Blocks[blocker]
+	i := slices.Index(blocks, blocked)
+
+	if i == -1 {
+		return false, nil
+	}
+
+	dbStructure.Blocks[blocker] = slices.Delete(blocks, i, i+1)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetBlocks returns every block, keyed by the blocking user
+func (db *DB) GetBlocks() (map[int][]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching blocks in GetBlocks: %v", err)
+		return nil, err
+	}
+
+	return dbStructure.Blocks, nil
+}
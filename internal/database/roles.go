@@ -0,0 +1,140 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+	"github.com/nilsboi/Chirpy/internal/passwords"
+)
+
+// role returns the role of the user, accounts without one are plain users
+func (u User) role() string {
+	if u.Role == "" {
+		return auth.RoleUser
+	}
+
+	return u.Role
+}
+
+// SetRole changes the role of a user. Access tokens carry the role, so all
+// sessions of the user are ended and the next login picks up the new role.
+func (db *DB) SetRole(userID int, role string) (User, error) {
+	if !auth.ValidRole(role) {
+		return User{}, errors.New("unknown role " + role)
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	user, ok := dbStructure.Users[userID]
+
+	if !ok {
+		return User{}, errors.New("User not found")
+	}
+
+	if user.role() == role {
+		user.Password = nil
+		return user, nil
+	}
+
+	user.Role = role
+	dbStructure.Users[userID] = user
+
+	revokeAllSessions(dbStructure, userID, time.Now().UTC())
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
+	}
+
+	user.Password = nil
+	return user, nil
+}
+
+// BootstrapAdmin makes the account of email an admin, creating it with
+// password if it doesn't exist. Operators run it from the command line, so
+// the address counts as verified.
+func (db *DB) BootstrapAdmin(email string, password string, policy passwords.Policy) (User, error) {
+	email, err := NormaliseEmail(email)
+
+	if err != nil {
+		return User{}, err
+	}
+
+	db.mux.RLock()
+	dbStructure, err := db.loadDB()
+	db.mux.RUnlock()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	if _, found := userByEmail(dbStructure, email); !found {
+		if password == "" {
+			return User{}, errors.New("a password is required to create the account")
+		}
+
+		_, err = db.CreateUser(email, password, policy)
+
+		if err != nil && !errors.Is(err, ErrEmailTaken) {
+			return User{}, err
+		}
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err = db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return User{}, err
+	}
+
+	user, found := userByEmail(dbStructure, email)
+
+	if !found {
+		return User{}, errors.New("User not found")
+	}
+
+	user.Role = auth.RoleAdmin
+	user.EmailVerified = true
+	dbStructure.Users[user.ID] = user
+
+	// sessions from before the promotion carry the old role
+	revokeAllSessions(dbStructure, user.ID, time.Now().UTC())
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return User{}, err
+	}
+
+	user.Password = nil
+	return user, nil
+}
+
+// userByEmail finds an account by its address, compared case-insensitively
+func userByEmail(dbStructure DBStructure, email string) (User, bool) {
+	for _, user := range dbStructure.Users {
+		if strings.EqualFold(user.Email, email) {
+			return user, true
+		}
+	}
+
+	return User{}, false
+}
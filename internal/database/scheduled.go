@@ -0,0 +1,213 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+type ScheduledChirp struct {
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	Author    int       `json:"author_id"`
+	PublishAt time.Time `json:"publish_at"`
+	ExpiresIn int       `json:"expires_in,omitempty"`
+
+	ContentWarning string `json:"content_warning,omitempty"`
+	Sensitive      bool   `json:"sensitive"`
+	Flagged        bool   `json:"flagged_for_review,omitempty"`
+	ReplyTo        int    `json:"reply_to,omitempty"`
+}
+
+// ScheduleChirp queues a chirp that gets published at publishAt.
+// The lifetime of an ephemeral chirp starts when it is published.
+// Polls can't be scheduled because their closing time is absolute.
+func (db *DB) ScheduleChirp(body string, userID int, publishAt time.Time, opts ChirpOptions) (ScheduledChirp, error) {
+	if opts.Poll != nil {
+		return ScheduledChirp{}, errors.New("polls can't be scheduled")
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return ScheduledChirp{}, err
+	}
+
+	user, ok := dbStructure.Users[userID]
+
+	if !ok {
+		return ScheduledChirp{}, errors.New("unauthorized")
+	}
+
+	max := 0
+
+	for _, scheduled := range dbStructure.Scheduled {
+		if scheduled.ID > max {
+			max = scheduled.ID
+		}
+	}
+
+	scheduled := ScheduledChirp{
+		ID:        max + 1,
+		Body:      body,
+		Author:    user.ID,
+		PublishAt: publishAt.UTC(),
+		ExpiresIn: int(opts.ExpiresIn.Seconds()),
+
+		ContentWarning: opts.ContentWarning,
+		Sensitive:      opts.Sensitive,
+		Flagged:        opts.Flagged,
+		ReplyTo:        opts.ReplyTo,
+	}
+
+	dbStructure.Scheduled[scheduled.ID] = scheduled
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return ScheduledChirp{}, err
+	}
+
+	return scheduled, nil
+}
+
+// GetScheduledChirps returns the queued chirps of a user, next due first
+func (db *DB) GetScheduledChirps(userID int) ([]ScheduledChirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching scheduled chirps: %v", err)
+		return nil, err
+	}
+
+	scheduled := []ScheduledChirp{}
+
+	for _, s := range dbStructure.Scheduled {
+		if s.Author == userID {
+			scheduled = append(scheduled, s)
+		}
+	}
+
+	slices.SortFunc(scheduled, func(a, b ScheduledChirp) int { return a.PublishAt.Compare(b.PublishAt) })
+
+	return scheduled, nil
+}
+
+// CancelScheduledChirp removes a queued chirp of the user
+func (db *DB) CancelScheduledChirp(userID int, id int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	scheduled, ok := dbStructure.Scheduled[id]
+
+	if !ok || scheduled.Author != userID {
+		return false, nil
+	}
+
+	delete(dbStructure.Scheduled, id)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RescheduleChirp moves a queued chirp of the user to a new time
+func (db *DB) RescheduleChirp(userID int, id int, publishAt time.Time) (ScheduledChirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return ScheduledChirp{}, err
+	}
+
+	scheduled, ok := dbStructure.Scheduled[id]
+
+	if !ok || scheduled.Author != userID {
+		return ScheduledChirp{}, errors.New("ID not found")
+	}
+
+	scheduled.PublishAt = publishAt.UTC()
+	dbStructure.Scheduled[id] = scheduled
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return ScheduledChirp{}, err
+	}
+
+	return scheduled, nil
+}
+
+// PublishDueChirps turns every scheduled chirp that is due into a real chirp
+func (db *DB) PublishDueChirps(now time.Time) ([]Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return nil, err
+	}
+
+	due := []ScheduledChirp{}
+
+	for _, scheduled := range dbStructure.Scheduled {
+		if !scheduled.PublishAt.After(now) {
+			due = append(due, scheduled)
+		}
+	}
+
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	slices.SortFunc(due, func(a, b ScheduledChirp) int { return a.PublishAt.Compare(b.PublishAt) })
+
+	published := []Chirp{}
+
+	for _, scheduled := range due {
+		published = append(published, insertChirp(&dbStructure, scheduled.Body, scheduled.Author, ChirpOptions{
+			ExpiresIn:      time.Duration(scheduled.ExpiresIn) * time.Second,
+			ContentWarning: scheduled.ContentWarning,
+			Sensitive:      scheduled.Sensitive,
+			Flagged:        scheduled.Flagged,
+			ReplyTo:        scheduled.ReplyTo,
+		}))
+		delete(dbStructure.Scheduled, scheduled.ID)
+	}
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return nil, err
+	}
+
+	return published, nil
+}
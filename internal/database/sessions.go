@@ -0,0 +1,254 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+)
+
+// sessionTouchInterval limits how often authenticated requests write last_used_at
+const sessionTouchInterval = 5 * time.Minute
+
+var ErrSessionRevoked = errors.New("session revoked")
+
+// Session is a single login of a user. Its id is the family of the refresh
+// tokens it rotates through and the sid claim of its access tokens.
+type Session struct {
+	ID        string     `json:"id"`
+	UserID    int        `json:"user_id"`
+	UserAgent string     `json:"user_agent"`
+	IP        string     `json:"ip"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  time.Time  `json:"last_used_at"`
+	Expires   time.Time  `json:"expires_at"`
+	Revoked   *time.Time `json:"revoked_at,omitempty"`
+
+	// ClientType picks the token lifetimes of the session from the policy
+	ClientType string `json:"client_type"`
+	// AccessLifetime is the access token lifetime in seconds asked for at login
+	AccessLifetime int `json:"access_lifetime,omitempty"`
+
+	// Current marks the session of the request, it is never stored
+	Current bool `json:"current"`
+}
+
+// Client describes the device a session was started from
+type Client struct {
+	UserAgent string
+	IP        string
+	Type      string
+}
+
+func (s Session) active(now time.Time) bool {
+	return s.Revoked == nil && !expiredAt(s.Expires, now)
+}
+
+// createSession starts a new session for the user
+func createSession(dbStructure DBStructure, userID int, client Client, lifetimes auth.Lifetimes, accessLifetime time.Duration, now time.Time) (Session, error) {
+	id, err := randomHex(16)
+
+	if err != nil {
+		return Session{}, err
+	}
+
+	session := Session{
+		ID:        id,
+		UserID:    userID,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		CreatedAt: now,
+		LastUsed:  now,
+		Expires:   now.Add(lifetimes.Refresh),
+
+		ClientType:     client.Type,
+		AccessLifetime: int(lifetimes.Access(accessLifetime).Seconds()),
+	}
+
+	dbStructure.Sessions[id] = session
+
+	return session, nil
+}
+
+// revokeSession ends a session together with all of its refresh tokens
+func revokeSession(dbStructure DBStructure, id string, now time.Time) {
+	revokeFamily(dbStructure, id, now)
+
+	session, ok := dbStructure.Sessions[id]
+
+	if ok && session.Revoked == nil {
+		session.Revoked = &now
+		dbStructure.Sessions[id] = session
+	}
+}
+
+// GetSessions returns the active sessions of a user, most recently used first
+func (db *DB) GetSessions(userID int, current string) ([]Session, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching sessions: %v", err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	sessions := []Session{}
+
+	for _, session := range dbStructure.Sessions {
+		if session.UserID == userID && session.active(now) {
+			session.Current = session.ID == current
+			sessions = append(sessions, session)
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b Session) int { return b.LastUsed.Compare(a.LastUsed) })
+
+	return sessions, nil
+}
+
+// TouchSession checks that the session of an access token is still active
+// and records its use. Writes are throttled to one per sessionTouchInterval,
+// so most requests only need the read lock.
+func (db *DB) TouchSession(userID int, id string, now time.Time) error {
+	db.mux.RLock()
+	dbStructure, err := db.loadDB()
+	db.mux.RUnlock()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return err
+	}
+
+	session, err := activeSession(dbStructure, userID, id, now)
+
+	if err != nil {
+		return err
+	}
+
+	if now.Sub(session.LastUsed) < sessionTouchInterval {
+		return nil
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	// the session may have been revoked or touched since the check
+	dbStructure, err = db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return err
+	}
+
+	session, err = activeSession(dbStructure, userID, id, now)
+
+	if err != nil || now.Sub(session.LastUsed) < sessionTouchInterval {
+		return err
+	}
+
+	session.LastUsed = now
+	dbStructure.Sessions[id] = session
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// activeSession returns the session id of the user if it is still active
+func activeSession(dbStructure DBStructure, userID int, id string, now time.Time) (Session, error) {
+	session, ok := dbStructure.Sessions[id]
+
+	if !ok || session.UserID != userID || !session.active(now) {
+		return Session{}, ErrSessionRevoked
+	}
+
+	return session, nil
+}
+
+// RevokeSession logs a single session of the user out
+func (db *DB) RevokeSession(userID int, id string) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	session, ok := dbStructure.Sessions[id]
+
+	if !ok || session.UserID != userID || !session.active(now) {
+		return false, nil
+	}
+
+	revokeSession(dbStructure, id, now)
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// revokeAllSessions ends every session of the user and returns how many were active
+func revokeAllSessions(dbStructure DBStructure, userID int, now time.Time) int {
+	revoked := 0
+
+	for id, session := range dbStructure.Sessions {
+		if session.UserID == userID && session.active(now) {
+			revokeSession(dbStructure, id, now)
+			revoked++
+		}
+	}
+
+	// refresh tokens from before sessions existed have no session record
+	for key, token := range dbStructure.Tokens {
+		if token.UserID == userID && token.Revoked == nil {
+			token.Revoked = &now
+			dbStructure.Tokens[key] = token
+		}
+	}
+
+	return revoked
+}
+
+// RevokeAllSessions logs the user out everywhere: every refresh token is
+// revoked and access tokens stop working because their session is gone
+func (db *DB) RevokeAllSessions(userID int) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return 0, err
+	}
+
+	revoked := revokeAllSessions(dbStructure, userID, time.Now().UTC())
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return 0, err
+	}
+
+	return revoked, nil
+}
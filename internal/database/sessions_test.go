@@ -0,0 +1,67 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+	"github.com/nilsboi/Chirpy/internal/passwords"
+)
+
+func TestTouchSession(t *testing.T) {
+	db, keys := testDB(t)
+	// RevokeSession reads the wall clock, so the session has to be live by it
+	now := time.Now().UTC()
+
+	user, err := db.CreateUser("touch@example.com", "a long enough passphrase", passwords.DefaultPolicy)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Login(user.Email, "a long enough passphrase", keys, auth.DefaultPolicy, Client{}, 0, now); err != nil {
+		t.Fatal(err)
+	}
+
+	dbStructure, _ := db.loadDB()
+	var id string
+
+	for sessionID := range dbStructure.Sessions {
+		id = sessionID
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Duration
+		lastUsed time.Duration
+	}{
+		{"within the interval", time.Minute, 0},
+		{"after the interval", sessionTouchInterval + time.Minute, sessionTouchInterval + time.Minute},
+		{"soon after the last write", sessionTouchInterval + 2*time.Minute, sessionTouchInterval + time.Minute},
+	}
+
+	for _, tt := range tests {
+		if err := db.TouchSession(user.ID, id, now.Add(tt.at)); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		dbStructure, _ := db.loadDB()
+
+		if got, want := dbStructure.Sessions[id].LastUsed, now.Add(tt.lastUsed); !got.Equal(want) {
+			t.Errorf("%s: LastUsed = %s, want %s", tt.name, got, want)
+		}
+	}
+
+	if err := db.TouchSession(user.ID+1, id, now); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("session of another user: got %v, want ErrSessionRevoked", err)
+	}
+
+	if _, err := db.RevokeSession(user.ID, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.TouchSession(user.ID, id, now.Add(time.Hour)); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("revoked session: got %v, want ErrSessionRevoked", err)
+	}
+}
@@ -0,0 +1,274 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+)
+
+// ErrTokenReuse is returned when a refresh token that was already rotated is presented again.
+// The whole token family is revoked at that point because one of the two holders is not the user.
+var ErrTokenReuse = errors.New("refresh token reuse detected")
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+// hashToken is how single-use secrets are stored, so a leaked database can't redeem them
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken stores a new refresh token of the family in the token table
+// and extends the session it belongs to
+func issueRefreshToken(dbStructure DBStructure, userID int, family string, lifetime time.Duration, now time.Time) (Token, error) {
+	tokenString, err := randomHex(32) // 256 bits
+
+	if err != nil {
+		return Token{}, err
+	}
+
+	token := Token{
+		TokenString: tokenString,
+		Expires:     now.Add(lifetime),
+		UserID:      userID,
+		Family:      family,
+	}
+
+	dbStructure.Tokens[tokenString] = token
+
+	if session, ok := dbStructure.Sessions[family]; ok {
+		session.Expires = token.Expires
+		dbStructure.Sessions[family] = session
+	}
+
+	return token, nil
+}
+
+// revokeFamily revokes every token that descends from the same login
+func revokeFamily(dbStructure DBStructure, family string, now time.Time) {
+	for key, token := range dbStructure.Tokens {
+		if token.Family == family && token.Revoked == nil {
+			token.Revoked = &now
+			dbStructure.Tokens[key] = token
+		}
+	}
+}
+
+// RefreshToken redeems a refresh token for a new access token and a new refresh token.
+// The presented token is revoked; presenting it again revokes its whole family.
+// Both lifetimes follow the policy of the client type the session logged in with.
+func (db *DB) RefreshToken(refreshToken string, keys *auth.Keyring, policy auth.Policy, client Client) (string, string, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error fetching tokens: %v", err)
+		return "", "", err
+	}
+
+	token, ok := dbStructure.Tokens[refreshToken]
+
+	if !ok {
+		return "", "", errors.New("invalid refresh token")
+	}
+
+	now := time.Now().UTC()
+
+	if token.ReplacedBy != "" {
+		log.Printf("Refresh token reuse for user %d, revoking family %s", token.UserID, token.Family)
+
+		if token.Family == "" {
+			token.Revoked = &now
+			dbStructure.Tokens[refreshToken] = token
+		} else {
+			revokeSession(dbStructure, token.Family, now)
+		}
+
+		if err := db.writeDB(dbStructure); err != nil {
+			log.Printf("Error writing database file: %v", err)
+			return "", "", err
+		}
+
+		return "", "", ErrTokenReuse
+	}
+
+	if token.Revoked != nil {
+		return "", "", errors.New("revoked")
+	}
+
+	if expired(token.Expires) {
+		return "", "", errors.New("expired")
+	}
+
+	// tokens from before sessions existed start a session of their own
+	session, ok := dbStructure.Sessions[token.Family]
+
+	if !ok {
+		session, err = createSession(dbStructure, token.UserID, client, policy.For(client.Type), 0, now)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		token.Family = session.ID
+	}
+
+	session.LastUsed = now
+	session.UserAgent = client.UserAgent
+	session.IP = client.IP
+	dbStructure.Sessions[session.ID] = session
+
+	// the policy may have changed since login, so clamp again
+	lifetimes := policy.For(session.ClientType)
+	accessLifetime := lifetimes.Access(time.Duration(session.AccessLifetime) * time.Second)
+
+	ss, err := auth.IssueAccessToken(token.UserID, session.ID, dbStructure.Users[token.UserID].role(), keys, accessLifetime)
+	if err != nil {
+		log.Print("Error signing token")
+		return "", "", errors.New("Problem with access token")
+	}
+
+	newToken, err := issueRefreshToken(dbStructure, token.UserID, token.Family, lifetimes.Refresh, now)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	token.Revoked = &now
+	token.ReplacedBy = newToken.TokenString
+	dbStructure.Tokens[refreshToken] = token
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return "", "", err
+	}
+
+	return ss, newToken.TokenString, nil
+}
+
+// RevokeToken ends the session of a refresh token, which revokes the token
+// and every access token issued for the session
+func (db *DB) RevokeToken(token string) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Print("Error saving access token")
+		return false, errors.New("error revoking token")
+	}
+
+	val, ok := dbStructure.Tokens[token]
+
+	if !ok || val.Revoked != nil {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	val.Revoked = &now
+	dbStructure.Tokens[token] = val
+
+	if val.Family != "" {
+		revokeSession(dbStructure, val.Family, now)
+	}
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Print("Error saving access token")
+		return false, errors.New("error revoking token")
+	}
+
+	return true, nil
+}
+
+// PurgeExpiredTokens drops refresh, reset and verification tokens and MFA
+// challenges whose lifetime ended before now, sessions that are over and
+// forgotten login failures. Rotated tokens are kept until they expire so
+// reuse can still be detected.
+func (db *DB) PurgeExpiredTokens(now time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		log.Printf("Error reading database file: %v", err)
+		return 0, err
+	}
+
+	purged := 0
+
+	for key, token := range dbStructure.Tokens {
+		if expiredAt(token.Expires, now) {
+			delete(dbStructure.Tokens, key)
+			purged++
+		}
+	}
+
+	for id, session := range dbStructure.Sessions {
+		if !session.active(now) {
+			delete(dbStructure.Sessions, id)
+			purged++
+		}
+	}
+
+	for hash, reset := range dbStructure.PasswordResets {
+		if expiredAt(reset.Expires, now) {
+			delete(dbStructure.PasswordResets, hash)
+			purged++
+		}
+	}
+
+	for hash, verification := range dbStructure.EmailVerifications {
+		if expiredAt(verification.Expires, now) {
+			delete(dbStructure.EmailVerifications, hash)
+			purged++
+		}
+	}
+
+	for hash, challenge := range dbStructure.MFAChallenges {
+		if expiredAt(challenge.Expires, now) {
+			delete(dbStructure.MFAChallenges, hash)
+			purged++
+		}
+	}
+
+	for key, attempt := range dbStructure.LoginAttempts {
+		if attempt.stale(now) {
+			delete(dbStructure.LoginAttempts, key)
+			purged++
+		}
+	}
+
+	if purged == 0 {
+		return 0, nil
+	}
+
+	err = db.writeDB(dbStructure)
+
+	if err != nil {
+		log.Printf("Error writing database file: %v", err)
+		return 0, err
+	}
+
+	return purged, nil
+}
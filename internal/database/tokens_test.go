@@ -0,0 +1,126 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nilsboi/Chirpy/internal/auth"
+	"github.com/nilsboi/Chirpy/internal/passwords"
+)
+
+// loggedIn creates a user without a second factor and logs it in by the wall
+// clock, which RefreshToken and RevokeToken read
+func loggedIn(t *testing.T) (*DB, *auth.Keyring, User) {
+	t.Helper()
+
+	db, keys := testDB(t)
+	const email, password = "tokens@example.com", "a long enough passphrase"
+
+	if _, err := db.CreateUser(email, password, passwords.DefaultPolicy); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.Login(email, password, keys, auth.DefaultPolicy, Client{}, 0, time.Now().UTC())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, keys, user
+}
+
+func sessionOf(t *testing.T, db *DB, refreshToken string) Session {
+	t.Helper()
+
+	dbStructure, err := db.loadDB()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dbStructure.Sessions[dbStructure.Tokens[refreshToken].Family]
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	db, keys, user := loggedIn(t)
+
+	access, next, err := db.RefreshToken(user.RefreshToken, keys, auth.DefaultPolicy, Client{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access == "" || next == "" || next == user.RefreshToken {
+		t.Fatalf("RefreshToken = %q, %q, want a new access and refresh token", access, next)
+	}
+
+	if sessionOf(t, db, next).ID != sessionOf(t, db, user.RefreshToken).ID {
+		t.Error("the rotated token left its session")
+	}
+
+	if _, _, err := db.RefreshToken(user.RefreshToken, keys, auth.DefaultPolicy, Client{}); !errors.Is(err, ErrTokenReuse) {
+		t.Errorf("second use of a rotated token: got %v, want ErrTokenReuse", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	db, keys, user := loggedIn(t)
+
+	second, third := "", user.RefreshToken
+
+	for range 2 {
+		_, next, err := db.RefreshToken(third, keys, auth.DefaultPolicy, Client{})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		second, third = third, next
+	}
+
+	// an attacker replays the first token after the user rotated twice
+	if _, _, err := db.RefreshToken(user.RefreshToken, keys, auth.DefaultPolicy, Client{}); !errors.Is(err, ErrTokenReuse) {
+		t.Fatalf("replayed token: got %v, want ErrTokenReuse", err)
+	}
+
+	for _, token := range []string{second, third} {
+		if _, _, err := db.RefreshToken(token, keys, auth.DefaultPolicy, Client{}); err == nil {
+			t.Errorf("token %s of the revoked family still refreshes", token[:8])
+		}
+	}
+
+	session := sessionOf(t, db, third)
+
+	if session.active(time.Now().UTC()) {
+		t.Error("the session of the revoked family is still active")
+	}
+
+	if err := db.TouchSession(user.ID, session.ID, time.Now().UTC()); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("access with the revoked session: got %v, want ErrSessionRevoked", err)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	db, keys, user := loggedIn(t)
+
+	revoked, err := db.RevokeToken(user.RefreshToken)
+
+	if err != nil || !revoked {
+		t.Fatalf("RevokeToken = %t, %v, want true", revoked, err)
+	}
+
+	if _, _, err := db.RefreshToken(user.RefreshToken, keys, auth.DefaultPolicy, Client{}); err == nil {
+		t.Error("a revoked token still refreshes")
+	}
+
+	if revoked, _ := db.RevokeToken(user.RefreshToken); revoked {
+		t.Error("revoking twice reported a second revocation")
+	}
+
+	session := sessionOf(t, db, user.RefreshToken)
+
+	if err := db.TouchSession(user.ID, session.ID, time.Now().UTC()); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("access after revoking: got %v, want ErrSessionRevoked", err)
+	}
+}
@@ -0,0 +1,293 @@
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionMask   Action = "mask"
+	ActionReject Action = "reject"
+	ActionFlag   Action = "flag"
+
+	mask = "****"
+)
+
+// Rule matches either a list of words or a regular expression.
+// Words are compared after normalisation, patterns run on the original text.
+type Rule struct {
+	Name    string   `json:"name"`
+	Words   []string `json:"words,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Action  Action   `json:"action"`
+}
+
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+type Match struct {
+	Rule   string `json:"rule"`
+	Action Action `json:"action"`
+	Term   string `json:"term"`
+}
+
+type Result struct {
+	Text     string
+	Rejected bool
+	Flagged  bool
+	Matches  []Match
+}
+
+// DefaultConfig masks the words the old hard-coded filter knew about
+var DefaultConfig = Config{
+	Rules: []Rule{
+		{Name: "profanity", Words: []string{"kerfuffle", "sharbert", "fornax"}, Action: ActionMask},
+	},
+}
+
+type compiledRule struct {
+	name    string
+	action  Action
+	words   map[string]bool
+	pattern *regexp.Regexp
+}
+
+// Filter applies the currently loaded rule set. The rules can be swapped
+// at any time, requests in flight keep using the set they started with.
+type Filter struct {
+	rules atomic.Pointer[[]compiledRule]
+}
+
+// New creates a filter from a parsed config
+func New(config Config) (*Filter, error) {
+	rules, err := compile(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Filter{}
+	f.rules.Store(&rules)
+
+	return f, nil
+}
+
+// Load reads the config file at path. A missing file falls back to DefaultConfig.
+func Load(path string) (*Filter, error) {
+	config, err := readConfig(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Filter config %s not found, using default rules", path)
+		return New(DefaultConfig)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return New(config)
+}
+
+// Reload replaces the rules with the content of the config file.
+// On error the current rules stay active.
+func (f *Filter) Reload(path string) error {
+	config, err := readConfig(path)
+
+	if err != nil {
+		return err
+	}
+
+	rules, err := compile(config)
+
+	if err != nil {
+		return err
+	}
+
+	f.rules.Store(&rules)
+
+	return nil
+}
+
+// Watch polls the config file and reloads the rules whenever it changes
+func (f *Filter) Watch(path string, interval time.Duration) {
+	var lastMod time.Time
+
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			info, err := os.Stat(path)
+
+			if err != nil || !info.ModTime().After(lastMod) {
+				continue
+			}
+
+			lastMod = info.ModTime()
+			err = f.Reload(path)
+
+			if err != nil {
+				log.Printf("Error reloading filter config: %v", err)
+				continue
+			}
+
+			log.Printf("Filter config %s reloaded", path)
+		}
+	}()
+}
+
+// Apply runs every rule against the text. Masked words are replaced with
+// "****"; reject and flag matches are reported in the result.
+func (f *Filter) Apply(text string) Result {
+	rules := *f.rules.Load()
+	result := Result{Text: text, Matches: []Match{}}
+	spans := []span{}
+
+	for _, rule := range rules {
+		var found []span
+
+		if rule.pattern != nil {
+			for _, loc := range rule.pattern.FindAllStringIndex(text, -1) {
+				found = append(found, span{loc[0], loc[1]})
+			}
+		} else {
+			for _, token := range tokenize(text) {
+				for _, candidate := range token.candidates {
+					if rule.words[candidate.normalised] {
+						found = append(found, candidate.span)
+						break
+					}
+				}
+			}
+		}
+
+		for _, s := range found {
+			result.Matches = append(result.Matches, Match{Rule: rule.name, Action: rule.action, Term: text[s.start:s.end]})
+
+			switch rule.action {
+			case ActionReject:
+				result.Rejected = true
+			case ActionFlag:
+				result.Flagged = true
+			case ActionMask:
+				spans = append(spans, s)
+			}
+		}
+	}
+
+	result.Text = replaceSpans(text, spans)
+
+	return result
+}
+
+type span struct {
+	start int
+	end   int
+}
+
+// replaceSpans masks every span, merging overlapping ones first
+func replaceSpans(text string, spans []span) string {
+	if len(spans) == 0 {
+		return text
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	last := 0
+
+	for _, s := range spans {
+		if s.end <= last {
+			continue
+		}
+
+		if s.start < last {
+			s.start = last
+		} else {
+			b.WriteString(text[last:s.start])
+			b.WriteString(mask)
+		}
+
+		last = s.end
+	}
+
+	b.WriteString(text[last:])
+
+	return b.String()
+}
+
+func readConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	err = json.Unmarshal(data, &config)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+func compile(config Config) ([]compiledRule, error) {
+	rules := make([]compiledRule, 0, len(config.Rules))
+
+	for i, rule := range config.Rules {
+		name := rule.Name
+
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		switch rule.Action {
+		case ActionMask, ActionReject, ActionFlag:
+		default:
+			return nil, fmt.Errorf("%s: unknown action %q", name, rule.Action)
+		}
+
+		if (rule.Pattern == "") == (len(rule.Words) == 0) {
+			return nil, fmt.Errorf("%s: needs either words or a pattern", name)
+		}
+
+		compiled := compiledRule{name: name, action: rule.Action}
+
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+
+			compiled.pattern = pattern
+		} else {
+			compiled.words = map[string]bool{}
+
+			for _, word := range rule.Words {
+				compiled.words[normalise(word)] = true
+			}
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	return rules, nil
+}
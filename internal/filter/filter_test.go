@@ -0,0 +1,163 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testConfig = Config{
+	Rules: []Rule{
+		{Name: "profanity", Words: []string{"kerfuffle", "fornax", "hell", "cunt"}, Action: ActionMask},
+		{Name: "spam", Pattern: `(?i)free\s+money`, Action: ActionReject},
+		{Name: "scams", Words: []string{"airdrop"}, Action: ActionReject},
+		{Name: "crypto", Words: []string{"crypto"}, Action: ActionFlag},
+	},
+}
+
+func TestApply(t *testing.T) {
+	f, err := New(testConfig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		want     string
+		rejected bool
+		flagged  bool
+		matches  int
+	}{
+		{"clean", "nothing to see here", "nothing to see here", false, false, 0},
+		{"mask", "what a kerfuffle", "what a ****", false, false, 1},
+		{"upper case", "WHAT A KERFUFFLE", "WHAT A ****", false, false, 1},
+		{"mixed case", "KerFuFFle", "****", false, false, 1},
+		{"accents", "a kérfüfflé", "a ****", false, false, 1},
+		{"combining marks", "forna\u0301x", "****", false, false, 1},
+		{"fullwidth", "ｆｏｒｎａｘ", "****", false, false, 1},
+		{"leetspeak", "k3rfuffl3 and f0rn@x", "**** and ****", false, false, 2},
+		{"leet symbol at the end", "f0rna×, h3ll", "f0rna×, ****", false, false, 1},
+		{"hidden zero-width space", "ker\u200bfuffle", "****", false, false, 1},
+		{"soft hyphen", "for\u00adnax", "****", false, false, 1},
+		{"trailing punctuation", "Kerfuffle!", "****!", false, false, 1},
+		{"quoted", `"fornax"`, `"****"`, false, false, 1},
+		{"several", "hell, kerfuffle, hell", "****, ****, ****", false, false, 3},
+		{"inside a town name", "greetings from Scunthorpe", "greetings from Scunthorpe", false, false, 0},
+		{"inside longer words", "hello shell kerfuffles", "hello shell kerfuffles", false, false, 0},
+		{"prefix of a word", "fornaxian", "fornaxian", false, false, 0},
+		{"reject word", "join the AIRDROP", "join the AIRDROP", true, false, 1},
+		{"reject pattern", "get FREE   money", "get FREE   money", true, false, 1},
+		{"pattern needs the exact text", "get fr3e money", "get fr3e money", false, false, 0},
+		{"flag", "crypto is up", "crypto is up", false, true, 1},
+		{"flag and mask", "cRyPt0 kerfuffle", "cRyPt0 ****", false, true, 2},
+		{"reject, flag and mask", "fornax crypto airdrop", "**** crypto airdrop", true, true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := f.Apply(tt.text)
+
+			if result.Text != tt.want {
+				t.Errorf("Text = %q, want %q", result.Text, tt.want)
+			}
+
+			if result.Rejected != tt.rejected || result.Flagged != tt.flagged {
+				t.Errorf("Rejected, Flagged = %t, %t, want %t, %t", result.Rejected, result.Flagged, tt.rejected, tt.flagged)
+			}
+
+			if len(result.Matches) != tt.matches {
+				t.Errorf("%d matches, want %d: %v", len(result.Matches), tt.matches, result.Matches)
+			}
+		})
+	}
+}
+
+func TestNormalise(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"Straße", "strasse"},
+		{"ŁÓDŹ", "lodz"},
+		{"Crème Brûlée", "creme brulee"},
+		{"ño", "no"},
+		{"1337", "ieet"},
+		{"$h!t", "shit"},
+		{"ｗｏｒｄ", "word"},
+		{"ﬁnﬂ", "finfl"},
+		{"Phở Hà Nội", "pho ha noi"},
+		{"İstanbul", "istanbul"},
+		{"Øresund Đà", "oresund da"},
+		{"Ṣ̂", "s"},
+		{"zero\u200dwidth\ufeff", "zerowidth"},
+	}
+
+	for _, tt := range tests {
+		if got := normalise(tt.word); got != tt.want {
+			t.Errorf("normalise(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"unknown action", Rule{Words: []string{"a"}, Action: "delete"}},
+		{"missing action", Rule{Words: []string{"a"}}},
+		{"neither words nor pattern", Rule{Action: ActionMask}},
+		{"words and pattern", Rule{Words: []string{"a"}, Pattern: "a", Action: ActionMask}},
+		{"invalid pattern", Rule{Pattern: "(", Action: ActionFlag}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(Config{Rules: []Rule{tt.rule}}); err == nil {
+				t.Error("New accepted an invalid rule")
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.json")
+
+	f, err := Load(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.Apply("sharbert").Text; got != "****" {
+		t.Fatalf("missing file: got %q, want the default rules", got)
+	}
+
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"rules": [{"name": "bugs", "words": ["bug"], "action": "reject"}]}`)
+
+	if err := f.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.Apply("a bug").Rejected || f.Apply("sharbert").Text != "sharbert" {
+		t.Error("Reload didn't replace the rules")
+	}
+
+	write(`{"rules": [{"name": "broken", "action": "mask"}]}`)
+
+	if err := f.Reload(path); err == nil {
+		t.Error("Reload accepted an invalid config")
+	}
+
+	if !f.Apply("a bug").Rejected {
+		t.Error("a failed Reload dropped the current rules")
+	}
+}
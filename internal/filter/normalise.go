@@ -0,0 +1,140 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// leet maps digits and symbols commonly used in place of letters
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+}
+
+// folds spells out the Latin letters that have no Unicode decomposition,
+// every other diacritic is split off by NFKD and dropped as a mark
+var folds = map[rune]string{
+	'ß': "ss",
+	'ł': "l",
+	'ø': "o",
+	'ı': "i",
+	'đ': "d",
+}
+
+// ignorable runes hide inside words without being visible
+func ignorable(r rune) bool {
+	return r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\u2060' || r == '\ufeff' || r == '\u00ad' ||
+		unicode.Is(unicode.Mn, r)
+}
+
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || ignorable(r) || leet[r] != 0
+}
+
+// foldRune lowercases a decomposed rune and spells out leet and undecomposable letters
+func foldRune(r rune) string {
+	r = unicode.ToLower(r)
+
+	if f, ok := folds[r]; ok {
+		return f
+	}
+
+	if l, ok := leet[r]; ok {
+		return string(l)
+	}
+
+	return string(r)
+}
+
+// normalise turns a word into the form word lists are compared in. NFKD maps
+// compatibility forms like fullwidth letters and ligatures to plain ones and
+// splits accents off as combining marks, which ignorable drops.
+func normalise(word string) string {
+	var b strings.Builder
+
+	for _, r := range norm.NFKD.String(word) {
+		if ignorable(r) {
+			continue
+		}
+
+		b.WriteString(foldRune(r))
+	}
+
+	return b.String()
+}
+
+type candidate struct {
+	span       span
+	normalised string
+}
+
+type token struct {
+	candidates []candidate
+}
+
+// tokenize splits text into words. Leet symbols belong to a word, but
+// trailing or leading ones are often just punctuation ("Kerfuffle!"),
+// so every token also offers a candidate without them.
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+
+	flush := func(end int) {
+		if start == -1 {
+			return
+		}
+
+		s := span{start, end}
+		t := token{candidates: []candidate{{s, normalise(text[s.start:s.end])}}}
+
+		trimmed := s
+		for trimmed.start < trimmed.end {
+			r, size := utf8.DecodeRuneInString(text[trimmed.start:])
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				break
+			}
+			trimmed.start += size
+		}
+		for trimmed.end > trimmed.start {
+			r, size := utf8.DecodeLastRuneInString(text[trimmed.start:trimmed.end])
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				break
+			}
+			trimmed.end -= size
+		}
+
+		if trimmed != s && trimmed.start < trimmed.end {
+			t.candidates = append(t.candidates, candidate{trimmed, normalise(text[trimmed.start:trimmed.end])})
+		}
+
+		tokens = append(tokens, t)
+		start = -1
+	}
+
+	for i, r := range text {
+		if wordRune(r) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+
+		flush(i)
+	}
+
+	flush(len(text))
+
+	return tokens
+}
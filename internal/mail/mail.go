@@ -0,0 +1,123 @@
+// Package mail sends the transactional emails of the API.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("line break in mail header")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a message or reports why it couldn't
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTP sends mail through an SMTP relay. STARTTLS is used when the server offers it.
+type SMTP struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTP creates a mailer for host:port. Without a username no AUTH is sent.
+func NewSMTP(host string, port int, username string, password string, from string) *SMTP {
+	var auth smtp.Auth
+
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTP{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		from: from,
+		auth: auth,
+	}
+}
+
+func (s *SMTP) Send(msg Message) error {
+	if err := checkHeaders(msg); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, render(s.from, msg, time.Now()))
+}
+
+// Outbox writes every message as an .eml file instead of sending it,
+// for development and for tests that run offline
+type Outbox struct {
+	dir  string
+	from string
+}
+
+// NewOutbox creates the outbox directory. It holds secrets like reset
+// tokens, so it must not be served over HTTP.
+func NewOutbox(dir string, from string) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0700)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Outbox{dir: dir, from: from}, nil
+}
+
+func (o *Outbox) Send(msg Message) error {
+	if err := checkHeaders(msg); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	name := now.Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+
+	return os.WriteFile(filepath.Join(o.dir, name), render(o.from, msg, now), 0600)
+}
+
+// checkHeaders keeps recipients and subjects from injecting headers
+func checkHeaders(msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return ErrInvalidHeader
+	}
+
+	return nil
+}
+
+// render formats msg as a plain text RFC 5322 message
+func render(from string, msg Message, now time.Time) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return b.Bytes()
+}
@@ -0,0 +1,306 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// maxPixels also caps the frames of an animation taken together
+	maxPixels       = 40_000_000
+	maxFrames       = 1_000
+	thumbnailSize   = 320
+	jpegQuality     = 90
+	thumbnailSuffix = "_thumb"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	errInvalidGIF      = errors.New("invalid GIF")
+)
+
+// Store keeps uploaded images in a content-addressed directory:
+// every file is named after the SHA-256 of its (cleaned) content.
+type Store struct {
+	dir       string
+	urlPrefix string
+}
+
+type Attachment struct {
+	Hash         string
+	MimeType     string
+	URL          string
+	ThumbnailURL string
+	Width        int
+	Height       int
+}
+
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// NewStore creates the media directory if it doesn't exist.
+// Files are served below urlPrefix.
+func NewStore(dir string, urlPrefix string) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir, urlPrefix: urlPrefix}, nil
+}
+
+// Save validates an uploaded image, strips its metadata by re-encoding it,
+// writes it together with a thumbnail and returns where both can be fetched.
+func (s *Store) Save(data []byte) (Attachment, error) {
+	mimeType := http.DetectContentType(data)
+	ext, ok := extensions[mimeType]
+
+	if !ok {
+		return Attachment{}, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	if config.Width*config.Height > maxPixels {
+		return Attachment{}, errors.New("image dimensions too large")
+	}
+
+	// the config only describes the first frame, DecodeAll decodes every one
+	if mimeType == "image/gif" {
+		frames, pixels, err := gifFrames(data)
+
+		if err != nil {
+			return Attachment{}, err
+		}
+
+		if frames > maxFrames || pixels > maxPixels {
+			return Attachment{}, errors.New("animation too large")
+		}
+	}
+
+	clean, img, err := reencode(data, mimeType)
+
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	sum := sha256.Sum256(clean)
+	hash := hex.EncodeToString(sum[:])
+
+	thumb, err := encode(thumbnail(img, thumbnailSize), mimeType)
+
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	name := hash + ext
+	thumbName := hash + thumbnailSuffix + ext
+
+	err = s.write(hash, name, clean)
+
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	err = s.write(hash, thumbName, thumb)
+
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	return Attachment{
+		Hash:         hash,
+		MimeType:     mimeType,
+		URL:          s.urlPrefix + hash[:2] + "/" + name,
+		ThumbnailURL: s.urlPrefix + hash[:2] + "/" + thumbName,
+		Width:        config.Width,
+		Height:       config.Height,
+	}, nil
+}
+
+// write stores a file below a two character fan-out directory.
+// Identical content maps to the same name, so existing files are kept as they are.
+func (s *Store) write(hash string, name string, data []byte) error {
+	dir := filepath.Join(s.dir, hash[:2])
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, name)
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(dir, "upload-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// gifFrames walks the blocks of a GIF without decoding any pixels and returns
+// the number of frames and the pixels of all frames together
+func gifFrames(data []byte) (int, int, error) {
+	// header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, 0, errInvalidGIF
+	}
+
+	pos := 13
+	pos += colorTableSize(data[10])
+	frames, pixels := 0, 0
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21:
+			// extension introducer and label, the data follows in sub-blocks
+			pos += 2
+		case 0x2C:
+			// image descriptor: position, size, flags, then the LZW code size
+			if pos+11 > len(data) {
+				return 0, 0, errInvalidGIF
+			}
+
+			width := int(binary.LittleEndian.Uint16(data[pos+5:]))
+			height := int(binary.LittleEndian.Uint16(data[pos+7:]))
+			pos += 10 + colorTableSize(data[pos+9]) + 1
+
+			frames++
+			pixels += width * height
+		case 0x3B:
+			return frames, pixels, nil
+		default:
+			return 0, 0, errInvalidGIF
+		}
+
+		// skip the sub-blocks up to the empty one that ends them
+		for {
+			if pos >= len(data) {
+				return 0, 0, errInvalidGIF
+			}
+
+			size := int(data[pos])
+			pos += 1 + size
+
+			if size == 0 {
+				break
+			}
+		}
+	}
+
+	// the trailer is missing
+	return 0, 0, errInvalidGIF
+}
+
+// colorTableSize returns the length of the color table that the flags of a
+// screen or image descriptor announce
+func colorTableSize(flags byte) int {
+	if flags&0x80 == 0 {
+		return 0
+	}
+
+	return 3 << (flags&0x07 + 1)
+}
+
+// reencode decodes and encodes the image again. Only pixel data survives,
+// which drops EXIF blocks, PNG text chunks and GIF comments.
+func reencode(data []byte, mimeType string) ([]byte, image.Image, error) {
+	if mimeType == "image/gif" {
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		clean := gif.GIF{
+			Image:     anim.Image,
+			Delay:     anim.Delay,
+			LoopCount: anim.LoopCount,
+			Disposal:  anim.Disposal,
+			Config:    anim.Config,
+		}
+
+		var buf bytes.Buffer
+		err = gif.EncodeAll(&buf, &clean)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return buf.Bytes(), anim.Image[0], nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clean, err := encode(img, mimeType)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return clean, img, nil
+}
+
+func encode(img image.Image, mimeType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch mimeType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = ErrUnsupportedType
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
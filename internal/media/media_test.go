@@ -0,0 +1,87 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+)
+
+// animation encodes a GIF with frames frames of width × height pixels
+func animation(t *testing.T, frames int, width int, height int) []byte {
+	t.Helper()
+
+	anim := gif.GIF{}
+
+	for range frames {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+
+	if err := gif.EncodeAll(&buf, &anim); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestGIFFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+		pixels int
+		err    bool
+	}{
+		{"single frame", animation(t, 1, 20, 10), 1, 200, false},
+		{"animation", animation(t, 7, 16, 16), 7, 7 * 256, false},
+		{"truncated", animation(t, 3, 16, 16)[:40], 0, 0, true},
+		{"too short", []byte("GIF89a"), 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, pixels, err := gifFrames(tt.data)
+
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %t", err, tt.err)
+			}
+
+			if frames != tt.frames || pixels != tt.pixels {
+				t.Errorf("gifFrames = (%d, %d), want (%d, %d)", frames, pixels, tt.frames, tt.pixels)
+			}
+		})
+	}
+}
+
+func TestSaveLimitsAnimations(t *testing.T) {
+	store, err := NewStore(t.TempDir(), "/media/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"small animation", animation(t, 10, 32, 32), true},
+		{"too many frames", animation(t, maxFrames+1, 1, 1), false},
+		// every frame is fine on its own, together they decode to too many pixels
+		{"too many pixels", animation(t, 3, 4000, 4000), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := store.Save(tt.data)
+
+			if (err == nil) != tt.ok {
+				t.Errorf("Save: err = %v, want success %t", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package media
+
+import (
+	"image"
+	"image/color"
+)
+
+// thumbnail scales the image down to fit into a size x size box.
+// Every target pixel is the average of the source pixels it covers,
+// which is good enough for previews and needs nothing beyond the standard library.
+func thumbnail(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if w <= size && h <= size {
+		return src
+	}
+
+	tw, th := size, h*size/w
+
+	if h > w {
+		tw, th = w*size/h, size
+	}
+
+	tw, th = max(tw, 1), max(th, 1)
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+
+	for y := 0; y < th; y++ {
+		y0 := bounds.Min.Y + y*h/th
+		y1 := max(bounds.Min.Y+(y+1)*h/th, y0+1)
+
+		for x := 0; x < tw; x++ {
+			x0 := bounds.Min.X + x*w/tw
+			x1 := max(bounds.Min.X+(x+1)*w/tw, x0+1)
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,101 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// prefixLength is how many hex digits of the hash a range query reveals
+const prefixLength = 5
+
+//go:embed breached.txt
+var breachedList string
+
+// Source answers k-anonymity range queries like the Pwned Passwords API: for
+// the first five hex digits of a SHA-1 it returns the other 35 digits of
+// every breached password hash with that prefix. The password itself and its
+// full hash never leave Breached, so a remote Source can be used the same way.
+type Source interface {
+	Range(prefix string) ([]string, error)
+}
+
+// HashFile is a Source held in memory
+type HashFile map[string][]string
+
+func (h HashFile) Range(prefix string) ([]string, error) {
+	return h[strings.ToUpper(prefix)], nil
+}
+
+// bundled is the breached list that ships with the binary
+var bundled = sync.OnceValue(func() HashFile {
+	file, err := readHashFile(strings.NewReader(breachedList))
+
+	if err != nil {
+		panic("bundled breached list: " + err.Error())
+	}
+
+	return file
+})
+
+// LoadHashFile reads a list of upper-case SHA-1 hashes, one per line. The
+// "HASH:COUNT" lines of the Pwned Passwords downloads work too.
+func LoadHashFile(path string) (HashFile, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return readHashFile(f)
+}
+
+func readHashFile(r io.Reader) (HashFile, error) {
+	file := HashFile{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+
+		if hash == "" || strings.HasPrefix(hash, "#") {
+			continue
+		}
+
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+
+		hash = strings.ToUpper(hash)
+		file[hash[:prefixLength]] = append(file[hash[:prefixLength]], hash[prefixLength:])
+	}
+
+	return file, scanner.Err()
+}
+
+// Breached reports whether password is in source. Only the hash prefix is
+// passed to the source, the suffixes are compared here.
+func Breached(source Source, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := source.Range(hash[:prefixLength])
+
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(suffixes, func(suffix string) bool {
+		return strings.EqualFold(suffix, hash[prefixLength:])
+	}), nil
+}
@@ -0,0 +1,179 @@
+// Package passwords decides which passwords users may choose. A Policy checks
+// the length, a bundled list of common passwords, the email of the account and
+// a list of breached passwords, and reports every rule a password breaks.
+package passwords
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// bcryptLimit is the number of bytes bcrypt hashes, longer passwords are refused
+const bcryptLimit = 72
+
+// names of the rules a Violation can report
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleCommon    = "common"
+	RuleEmail     = "email"
+	RuleBreached  = "breached"
+)
+
+//go:embed common.txt
+var commonList string
+
+// common holds the bundled common passwords in lower case
+var common = sync.OnceValue(func() map[string]bool {
+	set := map[string]bool{}
+
+	for _, line := range strings.Split(commonList, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			set[strings.ToLower(line)] = true
+		}
+	}
+
+	return set
+})
+
+type Policy struct {
+	// MinLength counts characters, MaxLength counts bytes because of bcrypt
+	MinLength     int  `json:"min_length"`
+	MaxLength     int  `json:"max_length"`
+	BanCommon     bool `json:"ban_common"`
+	RejectEmail   bool `json:"reject_email"`
+	CheckBreached bool `json:"check_breached"`
+	// BreachedFile replaces the bundled breached list, see LoadHashFile
+	BreachedFile string `json:"breached_file,omitempty"`
+
+	breached Source
+}
+
+var DefaultPolicy = Policy{
+	MinLength:     8,
+	MaxLength:     bcryptLimit,
+	BanCommon:     true,
+	RejectEmail:   true,
+	CheckBreached: true,
+}
+
+// Violation is a rule a password breaks
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every rule a rejected password breaks
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+
+	return "password rejected: " + strings.Join(messages, "; ")
+}
+
+// LoadPolicy reads the password policy from a JSON file. A missing file means
+// the default policy, fields the file leaves out keep their default.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Password policy %s not found, using defaults", path)
+		return DefaultPolicy, nil
+	}
+
+	if err != nil {
+		return Policy{}, err
+	}
+
+	policy := DefaultPolicy
+
+	err = json.Unmarshal(data, &policy)
+
+	if err != nil {
+		return Policy{}, err
+	}
+
+	if policy.MinLength < 1 {
+		return Policy{}, errors.New("min_length must be at least 1")
+	}
+
+	if policy.MaxLength < policy.MinLength || policy.MaxLength > bcryptLimit {
+		return Policy{}, fmt.Errorf("max_length must be between min_length and %d", bcryptLimit)
+	}
+
+	if policy.CheckBreached && policy.BreachedFile != "" {
+		policy.breached, err = LoadHashFile(policy.BreachedFile)
+
+		if err != nil {
+			return Policy{}, fmt.Errorf("breached_file: %w", err)
+		}
+	}
+
+	return policy, nil
+}
+
+// Check returns a *ValidationError naming every rule password breaks for the
+// account with email, or nil if the password is acceptable
+func (p Policy) Check(password string, email string) error {
+	violations := []Violation{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{RuleMinLength, fmt.Sprintf("must be at least %d characters long", p.MinLength)})
+	}
+
+	if len(password) > p.MaxLength {
+		violations = append(violations, Violation{RuleMaxLength, fmt.Sprintf("must be at most %d bytes long", p.MaxLength)})
+	}
+
+	if p.BanCommon && common()[strings.ToLower(password)] {
+		violations = append(violations, Violation{RuleCommon, "is too common"})
+	}
+
+	if p.RejectEmail && email != "" && matchesEmail(password, email) {
+		violations = append(violations, Violation{RuleEmail, "must not be the email address"})
+	}
+
+	if p.CheckBreached && password != "" {
+		source := p.breached
+
+		if source == nil {
+			source = bundled()
+		}
+
+		breached, err := Breached(source, password)
+
+		// an unavailable source shouldn't keep people from signing up
+		if err != nil {
+			log.Printf("Breached password check failed: %v", err)
+		} else if breached {
+			violations = append(violations, Violation{RuleBreached, "appeared in a data breach"})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+// matchesEmail reports whether password is the email or its local part
+func matchesEmail(password string, email string) bool {
+	local, _, _ := strings.Cut(email, "@")
+
+	return strings.EqualFold(password, email) || strings.EqualFold(password, local)
+}
@@ -0,0 +1,172 @@
+package passwords
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sha1("correct horse battery staple")
+const stapleHash = "ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42"
+
+func TestCheck(t *testing.T) {
+	policy := DefaultPolicy
+	policy.breached = HashFile{stapleHash[:prefixLength]: {stapleHash[prefixLength:]}}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"acceptable", "a long enough passphrase", nil},
+		{"too short", "short", []string{RuleMinLength}},
+		{"too short, counted in characters", "äöüäöüä", []string{RuleMinLength}},
+		{"multibyte, long enough", "äöüäöüäö", nil},
+		{"72 bytes", strings.Repeat("a", 72), nil},
+		{"73 bytes", strings.Repeat("a", 73), []string{RuleMaxLength}},
+		{"36 characters, 72 bytes", strings.Repeat("ä", 36), nil},
+		{"37 characters, 74 bytes", strings.Repeat("ä", 37), []string{RuleMaxLength}},
+		{"24 emoji, 96 bytes", strings.Repeat("😀", 24), []string{RuleMaxLength}},
+		{"common", "baseball", []string{RuleCommon}},
+		{"common in upper case", "BASEBALL", []string{RuleCommon}},
+		{"email", "jane.doe@example.com", []string{RuleEmail}},
+		{"email in upper case", "JANE.DOE@EXAMPLE.COM", []string{RuleEmail}},
+		{"local part", "jane.doe", []string{RuleEmail}},
+		{"local part inside a longer password", "jane.doe was here", nil},
+		{"breached", "correct horse battery staple", []string{RuleBreached}},
+		{"several at once", "qwerty", []string{RuleMinLength, RuleCommon}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, "jane.doe@example.com")
+
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Check = %v, want nil", err)
+				}
+
+				return
+			}
+
+			validation := &ValidationError{}
+
+			if !errors.As(err, &validation) {
+				t.Fatalf("Check = %v, want a *ValidationError", err)
+			}
+
+			rules := []string{}
+
+			for _, v := range validation.Violations {
+				rules = append(rules, v.Rule)
+			}
+
+			if !slices.Equal(rules, tt.want) {
+				t.Errorf("rules = %v, want %v", rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckWithoutRules(t *testing.T) {
+	policy := Policy{MinLength: 1, MaxLength: bcryptLimit}
+
+	for _, password := range []string{"password", "jane.doe", "correct horse battery staple"} {
+		if err := policy.Check(password, "jane.doe@example.com"); err != nil {
+			t.Errorf("Check(%q) = %v, want nil with every rule off", password, err)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	breached := write("breached.txt", stapleHash+":42\n")
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"empty object", `{}`, false},
+		{"shorter maximum", `{"max_length": 64}`, false},
+		{"maximum above the bcrypt limit", `{"max_length": 73}`, true},
+		{"maximum below the minimum", `{"min_length": 12, "max_length": 10}`, true},
+		{"minimum of zero", `{"min_length": 0}`, true},
+		{"negative minimum", `{"min_length": -1}`, true},
+		{"breached file", `{"breached_file": "` + breached + `"}`, false},
+		{"missing breached file", `{"breached_file": "` + filepath.Join(dir, "missing.txt") + `"}`, true},
+		{"invalid JSON", `{`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadPolicy(write("policy.json", tt.config))
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadPolicy = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	policy, err := LoadPolicy(filepath.Join(dir, "missing.json"))
+
+	if err != nil || policy != DefaultPolicy {
+		t.Errorf("missing file: got %+v, %v, want the default policy", policy, err)
+	}
+}
+
+func TestReadHashFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{"bare hashes", stapleHash + "\n", false},
+		{"HASH:COUNT lines", stapleHash + ":42\r\n", false},
+		{"lower case", strings.ToLower(stapleHash) + ":1\n", false},
+		{"comments and blank lines", "# breached\n\n" + stapleHash + "\n", false},
+		{"too short", stapleHash[:39] + ":1\n", true},
+		{"too long", stapleHash + "0\n", true},
+		{"not hex", "Z" + stapleHash[1:] + "\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := readHashFile(strings.NewReader(tt.file))
+
+			if tt.wantErr {
+				if err == nil {
+					t.Error("readHashFile accepted a bad hash")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if breached, _ := Breached(file, "correct horse battery staple"); !breached {
+				t.Error("hash not found")
+			}
+
+			if breached, _ := Breached(file, "correct horse battery stapler"); breached {
+				t.Error("other password found")
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+// Package search keeps an in-memory inverted index over chirp bodies.
+package search
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type Document struct {
+	ID       int
+	Author   int
+	Body     string
+	HasMedia bool
+}
+
+type docInfo struct {
+	author   int
+	hasMedia bool
+	length   int
+	terms    []string
+}
+
+// Index maps every stem to the chirps and word positions it occurs at
+type Index struct {
+	mu       sync.RWMutex
+	postings map[string]map[int][]int
+	docs     map[int]docInfo
+}
+
+func NewIndex() *Index {
+	return &Index{
+		postings: map[string]map[int][]int{},
+		docs:     map[int]docInfo{},
+	}
+}
+
+// Add indexes a chirp. Adding an id that is already indexed replaces it,
+// which is how edits are applied.
+func (idx *Index) Add(doc Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(doc.ID)
+
+	words := tokenize(doc.Body)
+	info := docInfo{author: doc.Author, hasMedia: doc.HasMedia, length: len(words)}
+	seen := map[string]bool{}
+
+	for pos, word := range words {
+		for _, stem := range stems(word) {
+			if idx.postings[stem] == nil {
+				idx.postings[stem] = map[int][]int{}
+			}
+
+			idx.postings[stem][doc.ID] = append(idx.postings[stem][doc.ID], pos)
+
+			if !seen[stem] {
+				seen[stem] = true
+				info.terms = append(info.terms, stem)
+			}
+		}
+	}
+
+	idx.docs[doc.ID] = info
+}
+
+// Remove drops a chirp from the index
+func (idx *Index) Remove(id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+}
+
+func (idx *Index) remove(id int) {
+	info, ok := idx.docs[id]
+
+	if !ok {
+		return
+	}
+
+	for _, term := range info.terms {
+		delete(idx.postings[term], id)
+
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+
+	delete(idx.docs, id)
+}
+
+// Rebuild replaces the whole index with the given chirps
+func (idx *Index) Rebuild(docs []Document) {
+	fresh := NewIndex()
+
+	for _, doc := range docs {
+		fresh.Add(doc)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.postings = fresh.postings
+	idx.docs = fresh.docs
+}
+
+// Query is a parsed search string. Words are combined with AND,
+// "quoted text" has to appear as a phrase.
+type Query struct {
+	Terms    [][]string
+	Phrases  [][][]string
+	Author   int
+	HasMedia bool
+}
+
+// Parse reads a search string with terms, "phrases", author:<id> and has:media
+func Parse(q string) Query {
+	query := Query{}
+
+	for i, part := range strings.Split(q, `"`) {
+		// every odd part was inside quotes
+		if i%2 == 1 {
+			phrase := [][]string{}
+
+			for _, word := range tokenize(part) {
+				phrase = append(phrase, stems(word))
+			}
+
+			if len(phrase) > 0 {
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(part) {
+			key, value, found := strings.Cut(strings.ToLower(field), ":")
+
+			if found && key == "author" {
+				query.Author, _ = strconv.Atoi(value)
+				continue
+			}
+
+			if found && key == "has" && value == "media" {
+				query.HasMedia = true
+				continue
+			}
+
+			for _, word := range tokenize(field) {
+				query.Terms = append(query.Terms, stems(word))
+			}
+		}
+	}
+
+	return query
+}
+
+func (q Query) empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && q.Author == 0 && !q.HasMedia
+}
+
+// Search returns the ids of matching chirps ranked by TF-IDF, newest first
+// on equal scores, together with the total number of matches. Chirps for
+// which visible returns false are left out before paging, so the total only
+// counts what can be shown. A nil visible keeps every chirp.
+func (idx *Index) Search(q Query, visible func(id int) bool, limit int, offset int) ([]int, int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if q.empty() {
+		return []int{}, 0
+	}
+
+	scores := map[int]float64{}
+
+	for _, id := range idx.candidates(q) {
+		info := idx.docs[id]
+
+		if q.Author != 0 && info.author != q.Author {
+			continue
+		}
+
+		if q.HasMedia && !info.hasMedia {
+			continue
+		}
+
+		if visible != nil && !visible(id) {
+			continue
+		}
+
+		score, ok := idx.score(id, info, q)
+
+		if ok {
+			scores[id] = score
+		}
+	}
+
+	ids := make([]int, 0, len(scores))
+
+	for id := range scores {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] > ids[j]
+	})
+
+	total := len(ids)
+
+	if offset >= total {
+		return []int{}, total
+	}
+
+	end := offset + limit
+
+	if limit <= 0 || end > total {
+		end = total
+	}
+
+	return ids[offset:end], total
+}
+
+// candidates returns the chirps that contain every word of the query. The
+// posting lists are intersected starting with the rarest word, so a common
+// word costs one lookup per remaining candidate. Queries with nothing but
+// filters have no posting list to start from and walk all chirps.
+func (idx *Index) candidates(q Query) []int {
+	words := slices.Clone(q.Terms)
+
+	for _, phrase := range q.Phrases {
+		words = append(words, phrase...)
+	}
+
+	if len(words) == 0 {
+		ids := make([]int, 0, len(idx.docs))
+
+		for id := range idx.docs {
+			ids = append(ids, id)
+		}
+
+		return ids
+	}
+
+	slices.SortFunc(words, func(a, b []string) int {
+		return idx.frequency(a) - idx.frequency(b)
+	})
+
+	ids := []int{}
+	seen := map[int]bool{}
+
+	for _, stem := range words[0] {
+		for id := range idx.postings[stem] {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	for _, variants := range words[1:] {
+		if len(ids) == 0 {
+			break
+		}
+
+		ids = slices.DeleteFunc(ids, func(id int) bool {
+			return !idx.contains(id, variants)
+		})
+	}
+
+	return ids
+}
+
+// frequency is the number of postings of all stem variants of a word, an
+// upper bound for the chirps containing it
+func (idx *Index) frequency(variants []string) int {
+	n := 0
+
+	for _, stem := range variants {
+		n += len(idx.postings[stem])
+	}
+
+	return n
+}
+
+func (idx *Index) contains(id int, variants []string) bool {
+	for _, stem := range variants {
+		if _, ok := idx.postings[stem][id]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// score sums the TF-IDF weights of all query words. A document has to
+// contain every term and every phrase to match.
+func (idx *Index) score(id int, info docInfo, q Query) (float64, bool) {
+	score := 0.0
+
+	for _, variants := range q.Terms {
+		weight, ok := idx.weight(id, info, variants)
+
+		if !ok {
+			return 0, false
+		}
+
+		score += weight
+	}
+
+	for _, phrase := range q.Phrases {
+		if !idx.containsPhrase(id, phrase) {
+			return 0, false
+		}
+
+		for _, variants := range phrase {
+			weight, _ := idx.weight(id, info, variants)
+			score += weight
+		}
+	}
+
+	return score, true
+}
+
+// weight returns the best TF-IDF weight of any stem variant of a word
+func (idx *Index) weight(id int, info docInfo, variants []string) (float64, bool) {
+	best, found := 0.0, false
+
+	for _, stem := range variants {
+		positions, ok := idx.postings[stem][id]
+
+		if !ok {
+			continue
+		}
+
+		tf := float64(len(positions)) / float64(max(info.length, 1))
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(idx.postings[stem])))
+
+		if w := tf * idf; !found || w > best {
+			best, found = w, true
+		}
+	}
+
+	return best, found
+}
+
+func (idx *Index) containsPhrase(id int, phrase [][]string) bool {
+	for _, start := range idx.positions(id, phrase[0]) {
+		matched := true
+
+		for offset, variants := range phrase[1:] {
+			if !slices.Contains(idx.positions(id, variants), start+offset+1) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (idx *Index) positions(id int, variants []string) []int {
+	positions := []int{}
+
+	for _, stem := range variants {
+		positions = append(positions, idx.postings[stem][id]...)
+	}
+
+	return positions
+}
+
+// tokenize lowercases text and splits it into words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
@@ -0,0 +1,89 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func testIndex() *Index {
+	idx := NewIndex()
+
+	idx.Rebuild([]Document{
+		{ID: 1, Author: 1, Body: "the cat sat on the mat"},
+		{ID: 2, Author: 1, Body: "cats and dogs", HasMedia: true},
+		{ID: 3, Author: 2, Body: "a dog sat on a cat"},
+		{ID: 4, Author: 2, Body: "nothing to see here"},
+		{ID: 5, Author: 3, Body: "the mat sat on the cat"},
+	})
+
+	return idx
+}
+
+func TestSearch(t *testing.T) {
+	idx := testIndex()
+
+	tests := []struct {
+		name    string
+		q       string
+		visible func(id int) bool
+		want    []int
+	}{
+		{"single term", "dog", nil, []int{2, 3}},
+		{"stemmed term", "cats", nil, []int{1, 2, 3, 5}},
+		{"all terms must match", "cat sat mat", nil, []int{1, 5}},
+		{"rare term first", "sat dogs", nil, []int{3}},
+		{"unknown term", "cat unicorn", nil, []int{}},
+		{"phrase", `"cat sat"`, nil, []int{1}},
+		{"phrase and term", `"sat on" dog`, nil, []int{3}},
+		{"author", "cat author:2", nil, []int{3}},
+		{"only filters", "author:1 has:media", nil, []int{2}},
+		{"visibility", "cat", func(id int) bool { return id != 3 }, []int{1, 2, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, total := idx.Search(Parse(tt.q), tt.visible, 0, 0)
+
+			slices.Sort(ids)
+
+			if !slices.Equal(ids, tt.want) || total != len(tt.want) {
+				t.Errorf("Search(%q) = %v, %d, want %v, %d", tt.q, ids, total, tt.want, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestSearchPagesVisibleChirps(t *testing.T) {
+	idx := testIndex()
+	visible := func(id int) bool { return id != 1 && id != 2 }
+
+	first, total := idx.Search(Parse("cat"), visible, 1, 0)
+	second, _ := idx.Search(Parse("cat"), visible, 1, 1)
+	rest, _ := idx.Search(Parse("cat"), visible, 1, 2)
+
+	if total != 2 {
+		t.Errorf("total = %d, want 2", total)
+	}
+
+	if len(first) != 1 || len(second) != 1 || len(rest) != 0 {
+		t.Fatalf("pages = %v, %v, %v, want one chirp on each of the first two", first, second, rest)
+	}
+
+	if first[0] == second[0] || !visible(first[0]) || !visible(second[0]) {
+		t.Errorf("pages = %v, %v, want chirps 3 and 5", first, second)
+	}
+}
+
+func TestSearchAfterRemove(t *testing.T) {
+	idx := testIndex()
+
+	idx.Remove(3)
+	idx.Add(Document{ID: 1, Author: 1, Body: "a dog now"})
+
+	ids, _ := idx.Search(Parse("dog"), nil, 0, 0)
+	slices.Sort(ids)
+
+	if !slices.Equal(ids, []int{1, 2}) {
+		t.Errorf("Search = %v, want [1 2]", ids)
+	}
+}
@@ -0,0 +1,77 @@
+package search
+
+import "strings"
+
+// Chirps are written in English and German, often in the same timeline.
+// Instead of guessing the language of every chirp, each word is indexed
+// under the stems of both languages.
+func stems(word string) []string {
+	en := stemEnglish(word)
+	de := stemGerman(word)
+
+	if en == de {
+		return []string{en}
+	}
+
+	return []string{en, de}
+}
+
+// stemEnglish strips the common inflectional suffixes. It is a light
+// stemmer in the spirit of Porter step 1, not the full algorithm.
+func stemEnglish(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+
+	word = strings.TrimSuffix(word, "'s")
+
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = word[:len(word)-1]
+	}
+
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed", "ly", "ness", "ment"} {
+		stem, ok := strings.CutSuffix(word, suffix)
+
+		if ok && len(stem) >= 3 && hasVowel(stem) {
+			word = stem
+
+			// hopping -> hop
+			if n := len(word); n >= 2 && word[n-1] == word[n-2] && !strings.ContainsRune("lsz", rune(word[n-1])) {
+				word = word[:n-1]
+			}
+			break
+		}
+	}
+
+	return strings.TrimSuffix(word, "e")
+}
+
+// stemGerman follows the light German stemmer by Savoy: umlauts are
+// folded and the usual plural and case endings removed.
+func stemGerman(word string) string {
+	word = strings.NewReplacer("ä", "a", "ö", "o", "ü", "u", "ß", "ss").Replace(word)
+
+	if len([]rune(word)) <= 4 {
+		return word
+	}
+
+	for _, suffix := range []string{"ern", "em", "er", "en", "es", "nd", "e", "s", "n"} {
+		stem, ok := strings.CutSuffix(word, suffix)
+
+		if ok && len([]rune(stem)) >= 3 {
+			return stem
+		}
+	}
+
+	return word
+}
+
+func hasVowel(word string) bool {
+	return strings.ContainsAny(word, "aeiouy")
+}
@@ -0,0 +1,326 @@
+package search
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type UserResult struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+type userKey struct {
+	key string
+	id  int
+}
+
+// typoPrefixLength is how many runes of a query typos are looked for in.
+// Beyond it a fuzzy match has to share the start of the query.
+const typoPrefixLength = 4
+
+// UserIndex answers @mention autocomplete queries without touching the
+// database. Usernames and the words of display names are kept in a sorted
+// slice, so a prefix lookup is a binary search. Typos are found the same
+// way, by looking up every start of a name one edit away from the query.
+type UserIndex struct {
+	mu        sync.RWMutex
+	users     map[int]UserResult
+	keys      []userKey
+	blockedBy map[int]map[int]bool
+	// alphabet holds every rune used in a key, the ones a typo could have replaced
+	alphabet []rune
+}
+
+func NewUserIndex() *UserIndex {
+	return &UserIndex{
+		users:     map[int]UserResult{},
+		blockedBy: map[int]map[int]bool{},
+	}
+}
+
+// Rebuild replaces all users and blocks. blocks maps a blocker to the users they blocked.
+func (idx *UserIndex) Rebuild(users []UserResult, blocks map[int][]int) {
+	fresh := NewUserIndex()
+
+	for _, user := range users {
+		fresh.users[user.ID] = user
+
+		for _, k := range keysOf(user) {
+			fresh.keys = append(fresh.keys, k)
+			fresh.addRunes(k.key)
+		}
+	}
+
+	sort.Slice(fresh.keys, func(i, j int) bool { return fresh.keys[i].key < fresh.keys[j].key })
+
+	for blocker, blocked := range blocks {
+		for _, id := range blocked {
+			fresh.block(blocker, id)
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.users = fresh.users
+	idx.keys = fresh.keys
+	idx.blockedBy = fresh.blockedBy
+	idx.alphabet = fresh.alphabet
+}
+
+// Put adds a user or updates the names of an indexed user
+func (idx *UserIndex) Put(user UserResult) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeKeys(user.ID)
+
+	if user.Username == "" && user.DisplayName == "" {
+		delete(idx.users, user.ID)
+		return
+	}
+
+	idx.users[user.ID] = user
+
+	for _, k := range keysOf(user) {
+		i := sort.Search(len(idx.keys), func(i int) bool { return idx.keys[i].key >= k.key })
+		idx.keys = append(idx.keys, userKey{})
+		copy(idx.keys[i+1:], idx.keys[i:])
+		idx.keys[i] = k
+		idx.addRunes(k.key)
+	}
+}
+
+func (idx *UserIndex) addRunes(key string) {
+	for _, r := range key {
+		if i, found := slices.BinarySearch(idx.alphabet, r); !found {
+			idx.alphabet = slices.Insert(idx.alphabet, i, r)
+		}
+	}
+}
+
+// Block records that blocker doesn't want to show up in searches of blocked
+func (idx *UserIndex) Block(blocker int, blocked int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.block(blocker, blocked)
+}
+
+func (idx *UserIndex) Unblock(blocker int, blocked int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.blockedBy[blocked], blocker)
+}
+
+func (idx *UserIndex) block(blocker int, blocked int) {
+	if idx.blockedBy[blocked] == nil {
+		idx.blockedBy[blocked] = map[int]bool{}
+	}
+
+	idx.blockedBy[blocked][blocker] = true
+}
+
+func (idx *UserIndex) removeKeys(id int) {
+	user, ok := idx.users[id]
+
+	if !ok {
+		return
+	}
+
+	for _, k := range keysOf(user) {
+		i := sort.Search(len(idx.keys), func(i int) bool { return idx.keys[i].key >= k.key })
+
+		for ; i < len(idx.keys) && idx.keys[i].key == k.key; i++ {
+			if idx.keys[i].id == id {
+				idx.keys = append(idx.keys[:i], idx.keys[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Search returns users whose username or display name starts with q.
+// When there are not enough prefix matches, names one typo away are added.
+// Users that blocked the caller are never returned.
+func (idx *UserIndex) Search(q string, caller int, limit int) []UserResult {
+	q = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(q), "@"))
+	results := []UserResult{}
+
+	if q == "" || limit <= 0 {
+		return results
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	seen := map[int]bool{}
+	add := func(id int) bool {
+		if seen[id] || idx.blockedBy[caller][id] {
+			return false
+		}
+
+		seen[id] = true
+		results = append(results, idx.users[id])
+
+		return len(results) >= limit
+	}
+
+	i := sort.Search(len(idx.keys), func(i int) bool { return idx.keys[i].key >= q })
+
+	for ; i < len(idx.keys) && strings.HasPrefix(idx.keys[i].key, q); i++ {
+		if add(idx.keys[i].id) {
+			return results
+		}
+	}
+
+	if utf8.RuneCountInString(q) < 3 {
+		return results
+	}
+
+	// every start a match can have is a range of the sorted keys. A range
+	// is sorted too, so it never needs to give more than limit matches.
+	matches := []userKey{}
+	visited := map[typoPrefix]bool{}
+
+	for _, prefix := range idx.typoPrefixes([]rune(q)) {
+		if visited[prefix] {
+			continue
+		}
+
+		visited[prefix] = true
+		found := 0
+		i := sort.Search(len(idx.keys), func(i int) bool { return idx.keys[i].key >= prefix.start })
+
+		for ; i < len(idx.keys) && found < limit && prefix.matches(idx.keys[i].key); i++ {
+			k := idx.keys[i]
+
+			if !seen[k.id] && !idx.blockedBy[caller][k.id] && fuzzyPrefix(k.key, q) {
+				matches = append(matches, k)
+				found++
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].key < matches[j].key })
+
+	for _, k := range matches {
+		if add(k.id) {
+			return results
+		}
+	}
+
+	return results
+}
+
+// typoPrefix is a start of the keys that may be one typo away from a query.
+// A whole key has to equal it.
+type typoPrefix struct {
+	start string
+	whole bool
+}
+
+func (p typoPrefix) matches(key string) bool {
+	if p.whole {
+		return key == p.start
+	}
+
+	return strings.HasPrefix(key, p.start)
+}
+
+// typoPrefixes lists where fuzzyPrefix matches of q can be in the keys: q
+// with one rune dropped as a whole key, and every start that has one rune of
+// q replaced or one rune added. Typos after typoPrefixLength runes leave the
+// start of q as it is.
+func (idx *UserIndex) typoPrefixes(q []rune) []typoPrefix {
+	n := min(len(q), typoPrefixLength)
+	prefixes := []typoPrefix{{start: string(q[:n])}}
+
+	for i := range q {
+		prefixes = append(prefixes, typoPrefix{start: string(q[:i]) + string(q[i+1:]), whole: true})
+	}
+
+	for i := range n {
+		for _, r := range idx.alphabet {
+			prefixes = append(prefixes,
+				typoPrefix{start: string(q[:i]) + string(r) + string(q[i+1:n])},
+				typoPrefix{start: string(q[:i]) + string(r) + string(q[i:n-1])},
+			)
+		}
+	}
+
+	return prefixes
+}
+
+func keysOf(user UserResult) []userKey {
+	keys := []userKey{}
+
+	if user.Username != "" {
+		keys = append(keys, userKey{strings.ToLower(user.Username), user.ID})
+	}
+
+	for _, word := range strings.Fields(strings.ToLower(user.DisplayName)) {
+		keys = append(keys, userKey{word, user.ID})
+	}
+
+	return keys
+}
+
+// fuzzyPrefix reports whether key starts with q allowing one typo. The
+// start of key is compared at the length of q and one rune longer, so a
+// missing letter in q is found as well.
+func fuzzyPrefix(key string, q string) bool {
+	kr, n := []rune(key), utf8.RuneCountInString(q)
+
+	for _, l := range []int{n, n + 1} {
+		if withinOneEdit(string(kr[:min(l, len(kr))]), q) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withinOneEdit reports whether a and b differ by at most one insertion,
+// deletion or substitution
+func withinOneEdit(a string, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+
+	if len(ra) < len(rb) {
+		ra, rb = rb, ra
+	}
+
+	if len(ra)-len(rb) > 1 {
+		return false
+	}
+
+	i, j, edits := 0, 0, 0
+
+	for i < len(ra) && j < len(rb) {
+		if ra[i] == rb[j] {
+			i++
+			j++
+			continue
+		}
+
+		edits++
+
+		if edits > 1 {
+			return false
+		}
+
+		if len(ra) == len(rb) {
+			j++
+		}
+
+		i++
+	}
+
+	return edits+(len(ra)-i) <= 1
+}
@@ -0,0 +1,115 @@
+package search
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestUserSearch(t *testing.T) {
+	idx := NewUserIndex()
+
+	idx.Rebuild([]UserResult{
+		{ID: 1, Username: "alice", DisplayName: "Alice Liddell"},
+		{ID: 2, Username: "charlie", DisplayName: "Charlie Chaplin"},
+		{ID: 3, Username: "bob", DisplayName: "Robert Builder"},
+		{ID: 5, Username: "bernd", DisplayName: "Bernd Brot"},
+		{ID: 6, Username: "jürgen"},
+	}, map[int][]int{2: {3}})
+
+	idx.Put(UserResult{ID: 7, Username: "alfred"})
+	idx.Put(UserResult{ID: 5, Username: "brot"})
+
+	tests := []struct {
+		name   string
+		q      string
+		caller int
+		want   []int
+	}{
+		{"prefix", "cha", 0, []int{2}},
+		{"at sign and case", "@CHAR", 0, []int{2}},
+		{"display name word", "liddell", 0, []int{1}},
+		{"prefix and fuzzy matches", "ali", 0, []int{1, 7}},
+		{"no fuzzy matches below three letters", "xl", 0, []int{}},
+		{"substitution", "alixe", 0, []int{1}},
+		{"missing letter", "alce", 0, []int{1}},
+		{"extra letter", "allice", 0, []int{1}},
+		{"typo in the first letter", "qlice", 0, []int{1}},
+		{"missing first letter", "lice", 0, []int{1}},
+		{"non-ASCII", "jurgen", 0, []int{6}},
+		{"two typos", "qlixe", 0, []int{}},
+		{"blocked caller", "char", 3, []int{}},
+		{"added user", "alfr", 0, []int{7}},
+		{"renamed user", "brot", 0, []int{5}},
+		{"old name is gone", "bernd", 0, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := []int{}
+
+			for _, user := range idx.Search(tt.q, tt.caller, 10) {
+				ids = append(ids, user.ID)
+			}
+
+			slices.Sort(ids)
+
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.q, ids, tt.want)
+			}
+		})
+	}
+}
+
+// syntheticUsers makes n users with pronounceable, often similar names
+func syntheticUsers(n int) []UserResult {
+	rng := rand.New(rand.NewPCG(1, 2))
+	syllables := []string{"an", "ber", "chri", "da", "el", "fa", "gun", "ha", "in", "jo", "ka", "lu", "ma", "ni", "o", "pe", "ri", "sa", "ti", "ul", "vi", "wa", "xe", "yu", "ze"}
+
+	name := func(parts int) string {
+		s := ""
+
+		for range parts {
+			s += syllables[rng.IntN(len(syllables))]
+		}
+
+		return s
+	}
+
+	users := make([]UserResult, 0, n)
+
+	for i := range n {
+		users = append(users, UserResult{
+			ID:          i + 1,
+			Username:    fmt.Sprintf("%s%d", name(2+rng.IntN(2)), rng.IntN(100)),
+			DisplayName: name(2) + " " + name(3),
+		})
+	}
+
+	return users
+}
+
+func BenchmarkSearchUsers(b *testing.B) {
+	idx := NewUserIndex()
+	idx.Rebuild(syntheticUsers(100_000), nil)
+
+	queries := []struct {
+		name string
+		q    string
+	}{
+		{"short prefix", "ma"},
+		{"prefix", "berda"},
+		{"typo", "bxrdama"},
+		{"typo in the first letter", "xerdama"},
+		{"no match", "qqqqqq"},
+	}
+
+	for _, query := range queries {
+		b.Run(query.name, func(b *testing.B) {
+			for range b.N {
+				idx.Search(query.q, 0, 10)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// the RFC lists 8 digit codes, these are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1_700_000_010, 0)
+	code, err := Code(rfcSecret, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		input  string
+		at     time.Time
+		skew   int
+		ok     bool
+		step   int64
+	}{
+		{"same step", rfcSecret, code, now, 1, true, Step(now)},
+		{"end of the same step", rfcSecret, code, now.Add(19 * time.Second), 0, true, Step(now)},
+		{"one step later", rfcSecret, code, now.Add(Period), 1, true, Step(now)},
+		{"one step earlier", rfcSecret, code, now.Add(-Period), 1, true, Step(now)},
+		{"two steps later", rfcSecret, code, now.Add(2 * Period), 1, false, 0},
+		{"one step later without skew", rfcSecret, code, now.Add(Period), 0, false, 0},
+		{"spaces are ignored", rfcSecret, code[:3] + " " + code[3:], now, 1, true, Step(now)},
+		{"lower-case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code, now, 0, true, Step(now)},
+		{"too short", rfcSecret, code[:5], now, 1, false, 0},
+		{"too long", rfcSecret, code + "0", now, 1, false, 0},
+		{"empty secret", "", code, now, 1, false, 0},
+		{"invalid secret", "not base32!", code, now, 1, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.input, tt.at, tt.skew)
+
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("Validate = (%d, %t), want (%d, %t)", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := GenerateSecret()
+
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+
+	if _, err := Code(a, time.Now()); err != nil {
+		t.Errorf("generated secret doesn't decode: %v", err)
+	}
+}
@@ -1,24 +1,72 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
+	"github.com/nilsboi/Chirpy/internal/auth"
+	"github.com/nilsboi/Chirpy/internal/chirplen"
 	"github.com/nilsboi/Chirpy/internal/database"
+	"github.com/nilsboi/Chirpy/internal/filter"
+	"github.com/nilsboi/Chirpy/internal/mail"
+	"github.com/nilsboi/Chirpy/internal/media"
+	"github.com/nilsboi/Chirpy/internal/passwords"
+	"github.com/nilsboi/Chirpy/internal/search"
+	"github.com/nilsboi/Chirpy/internal/totp"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	schedulerInterval   = 10 * time.Second
+	filterWatchInterval = 5 * time.Second
+	sweeperInterval     = time.Minute
+	keyCheckInterval    = time.Hour
+
+	defaultKeyRotation        = 30 * 24 * time.Hour
+	passwordResetLifetime     = 30 * time.Minute
+	emailVerificationLifetime = 24 * time.Hour
+	totpIssuer                = "Chirpy"
+	jwksCacheControl          = "public, max-age=300"
+
+	defaultMaxChirpLength        = 140
+	defaultMaxChirpLengthPremium = 280
+	maxDraftLength               = 10000
+
+	maxUploadSize        = 5 << 20
+	maxUploadSizePremium = 20 << 20
+	mediaCacheControl    = "public, max-age=31536000, immutable"
 )
 
 type apiConfig struct {
 	fileserverHits int
-	jwt            string
+	keys           *auth.Keyring
+	tokenPolicy    auth.Policy
+	passwordPolicy passwords.Policy
+	mailer         mail.Mailer
+	filter         *filter.Filter
+	search         *search.Index
+	users          *search.UserIndex
+	// chirp length limits per plan, counted with chirplen
+	maxChirpLength        int
+	maxChirpLengthPremium int
 }
 
 type returnError struct {
@@ -26,6 +74,22 @@ type returnError struct {
 	Error string `json:"error"`
 }
 
+type returnFilterError struct {
+	Error   string         `json:"error"`
+	Matches []filter.Match `json:"matches"`
+}
+
+type returnPasswordError struct {
+	Error      string                `json:"error"`
+	Violations []passwords.Violation `json:"violations"`
+}
+
+type returnLengthError struct {
+	Error  string `json:"error"`
+	Length int    `json:"length"`
+	Limit  int    `json:"limit"`
+}
+
 type returnValid struct {
 	// the key will be the name of struct field unless you give it an explicit JSON tag
 	Cleaned_body string `json:"cleaned_body"`
@@ -33,18 +97,52 @@ type returnValid struct {
 
 type returnToken struct {
 	// the key will be the name of struct field unless you give it an explicit JSON tag
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+type returnTOTP struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type returnRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 type parameters struct {
 	// these tags indicate how the keys in the JSON should be mapped to the struct fields
 	// the struct fields must be exported (start with a capital letter) if you want them parsed
-	Body             string `json:"body"`
-	Email            string `json:"email"`
-	Password         string `json:"password"`
-	ExpiresInSeconds int    `json:"expires_in_seconds,omitempty"`
-	Event string `json:"event"`
-	Data data `json:"data"`
+	Body             string          `json:"body"`
+	Email            string          `json:"email"`
+	Password         string          `json:"password"`
+	ExpiresInSeconds int             `json:"expires_in_seconds,omitempty"`
+	ClientType       string          `json:"client_type,omitempty"`
+	Event            string          `json:"event"`
+	Data             data            `json:"data"`
+	ChirpID          int             `json:"chirp_id"`
+	UserID           int             `json:"user_id"`
+	Name             string          `json:"name"`
+	Username         string          `json:"username"`
+	DisplayName      string          `json:"display_name"`
+	PublishAt        *time.Time      `json:"publish_at,omitempty"`
+	ExpiresIn        int             `json:"expires_in,omitempty"`
+	Poll             *pollParameters `json:"poll,omitempty"`
+	Option           int             `json:"option"`
+	ContentWarning   string          `json:"content_warning"`
+	Sensitive        bool            `json:"sensitive"`
+	ReplyTo          int             `json:"reply_to,omitempty"`
+	AutoExpand       *bool           `json:"auto_expand_sensitive,omitempty"`
+	Token            string          `json:"token"`
+	MFAToken         string          `json:"mfa_token"`
+	Code             string          `json:"code"`
+	Scopes           []string        `json:"scopes"`
+	Role             string          `json:"role"`
+}
+
+type pollParameters struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
 }
 
 type data struct {
@@ -88,77 +186,2337 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(dat)
 }
 
-func checkWords(msg string) string {
+type contextKey string
+
+const (
+	userIDKey    contextKey = "userID"
+	sessionIDKey contextKey = "sessionID"
+	roleKey      contextKey = "role"
+)
+
+// middlewareAuth only lets requests with a valid access token of an active
+// session through and puts the user and session into the request context
+func (cfg *apiConfig) middlewareAuth(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareScope("", next)
+}
+
+// middlewareScope is middlewareAuth for endpoints bots may call: it also
+// accepts API keys that were granted scope. Without a scope API keys are refused.
+func (cfg *apiConfig) middlewareScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString, err := auth.BearerToken(r.Header)
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		if auth.IsAPIKey(tokenString) {
+			if scope == "" {
+				respondWithError(w, 403, "API keys can't be used for this endpoint")
+				return
+			}
+
+			userID, err := db.AuthenticateAPIKey(tokenString, scope, time.Now().UTC())
+
+			if errors.Is(err, database.ErrMissingScope) {
+				respondWithError(w, 403, err.Error()+" "+scope)
+				return
+			}
+
+			if err != nil {
+				respondWithError(w, 401, "Unauthorized: "+err.Error())
+				return
+			}
+
+			next(w, r.WithContext(context.WithValue(r.Context(), userIDKey, userID)))
+			return
+		}
+
+		claims, err := auth.ValidateAccessToken(tokenString, cfg.keys)
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		// a signature alone is not enough, the session may have been logged out
+		err = db.TouchSession(claims.UserID, claims.SessionID, time.Now().UTC())
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+		ctx = context.WithValue(ctx, roleKey, claims.Role)
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// middlewareRole is middlewareAuth for admin and moderation endpoints: the
+// role claim of the access token has to grant at least role. API keys carry
+// no role and are refused by middlewareAuth already.
+func (cfg *apiConfig) middlewareRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.HasRole(roleFromContext(r.Context()), role) {
+			respondWithError(w, 403, "Forbidden: requires role "+role)
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// middlewareOptionalAuth is middlewareScope for public endpoints: requests
+// without an Authorization header pass through anonymously
+func (cfg *apiConfig) middlewareOptionalAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+	authenticated := cfg.middlewareScope(scope, next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+
+		authenticated(w, r)
+	}
+}
+
+// userIDFromContext returns the authenticated user, 0 for anonymous requests
+func userIDFromContext(ctx context.Context) int {
+	userID, _ := ctx.Value(userIDKey).(int)
+	return userID
+}
+
+// roleFromContext returns the role claim of the access token of the request
+func roleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey).(string)
+	return role
+}
+
+// sessionIDFromContext returns the session of the access token of the request
+func sessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey).(string)
+	return sessionID
+}
+
+// clientInfo describes the device of a request for its session record.
+// X-Forwarded-For is ignored because anyone can send it.
+func clientInfo(r *http.Request) database.Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return database.Client{UserAgent: r.UserAgent(), IP: ip}
+}
+
+// pageParams reads limit and offset from the query string
+func pageParams(r *http.Request) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+// runEvery runs job right away and then once per interval in the background
+func runEvery(interval time.Duration, job func()) {
+	go func() {
+		job()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			job()
+		}
+	}()
+}
+
+// startScheduler publishes due scheduled chirps in the background.
+// It runs once right away so chirps that fell due while the server was down go out on startup.
+func (cfg *apiConfig) startScheduler(path string, interval time.Duration) {
+	runEvery(interval, func() {
+		db, err := database.NewDB(path)
+
+		if err != nil {
+			log.Printf("Scheduler konnte die DB nicht öffnen: %v", err)
+			return
+		}
+
+		published, err := db.PublishDueChirps(time.Now().UTC())
+
+		if err != nil {
+			log.Printf("Scheduler konnte Chirps nicht veröffentlichen: %v", err)
+			return
+		}
+
+		for _, chirp := range published {
+			cfg.search.Add(searchDocument(chirp))
+			log.Printf("Geplanter Chirp %d veröffentlicht", chirp.ID)
+		}
+	})
+}
+
+// startSweeper purges expired chirps and refresh tokens in the background
+func (cfg *apiConfig) startSweeper(path string, interval time.Duration) {
+	runEvery(interval, func() {
+		db, err := database.NewDB(path)
+
+		if err != nil {
+			log.Printf("Sweeper konnte die DB nicht öffnen: %v", err)
+			return
+		}
+
+		purged, err := db.PurgeExpiredChirps(time.Now().UTC())
+
+		if err != nil {
+			log.Printf("Sweeper konnte Chirps nicht löschen: %v", err)
+			return
+		}
+
+		for _, chirp := range purged {
+			cfg.search.Remove(chirp.ID)
+			log.Printf("Abgelaufener Chirp %d gelöscht", chirp.ID)
+		}
+
+		tokens, err := db.PurgeExpiredTokens(time.Now().UTC())
+
+		if err != nil {
+			log.Printf("Sweeper konnte Tokens nicht löschen: %v", err)
+			return
+		}
+
+		if tokens > 0 {
+			log.Printf("%d abgelaufene Tokens und Sessions gelöscht", tokens)
+		}
+	})
+}
+
+// buildSearchIndex indexes every visible chirp of the database
+func (cfg *apiConfig) buildSearchIndex(path string) error {
+	db, err := database.NewDB(path)
+
+	if err != nil {
+		return err
+	}
+
+	chirps, err := db.GetChirps("", "", false, 0)
+
+	if err != nil {
+		return err
+	}
+
+	docs := make([]search.Document, 0, len(chirps))
+
+	for _, chirp := range chirps {
+		docs = append(docs, searchDocument(chirp))
+	}
+
+	cfg.search.Rebuild(docs)
+
+	return nil
+}
+
+// buildUserIndex loads all profiles and blocks for user search
+func (cfg *apiConfig) buildUserIndex(path string) error {
+	db, err := database.NewDB(path)
+
+	if err != nil {
+		return err
+	}
+
+	users, err := db.GetUsers()
+
+	if err != nil {
+		return err
+	}
+
+	blocks, err := db.GetBlocks()
+
+	if err != nil {
+		return err
+	}
+
+	results := make([]search.UserResult, 0, len(users))
+
+	for _, user := range users {
+		if user.Username != "" {
+			results = append(results, userResult(user))
+		}
+	}
+
+	cfg.users.Rebuild(results, blocks)
+
+	return nil
+}
+
+func userResult(user database.User) search.UserResult {
+	return search.UserResult{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+	}
+}
+
+func searchDocument(chirp database.Chirp) search.Document {
+	return search.Document{
+		ID:       chirp.ID,
+		Author:   chirp.Author,
+		Body:     chirp.Body,
+		HasMedia: len(chirp.Media) > 0,
+	}
+}
+
+// checkLength validates the length of a chirp body against the plan of the
+// user and writes the error response itself when the body is too long
+func (cfg *apiConfig) checkLength(w http.ResponseWriter, body string, premium bool) bool {
+	limit := cfg.maxChirpLength
+
+	if premium {
+		limit = cfg.maxChirpLengthPremium
+	}
+
+	length := chirplen.Count(body)
+
+	if length > limit {
+		respondWithJSON(w, 400, returnLengthError{
+			Error:  "Chirp is too long",
+			Length: length,
+			Limit:  limit,
+		})
+		return false
+	}
+
+	return true
+}
+
+// requireVerifiedEmail keeps accounts that never proved their email from
+// posting and writes the error response itself
+func requireVerifiedEmail(w http.ResponseWriter, user database.User) bool {
+	if !user.EmailVerified {
+		respondWithError(w, 403, "Verify your email address before posting")
+		return false
+	}
+
+	return true
+}
+
+// sendVerification mails a token that confirms email to the user
+func (cfg *apiConfig) sendVerification(db *database.DB, userID int, email string) error {
+	token, err := db.CreateEmailVerification(userID, email, emailVerificationLifetime)
+
+	if err != nil {
+		return err
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      email,
+		Subject: "Confirm your email address for Chirpy",
+		Body: "Please confirm that this address belongs to your Chirpy account.\n\n" +
+			"Your verification token is:\n\n    " + token + "\n\n" +
+			"It expires in " + emailVerificationLifetime.String() + ". " +
+			"If you didn't sign up for Chirpy, you can ignore this email.\n",
+	})
+
+	return nil
+}
+
+// envInt reads a positive integer from the environment
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
+
+// dataDir is where secrets like signing keys and the mail outbox live unless
+// env names a directory. It must not be below the working directory because
+// /app/ serves that directory as it is.
+func dataDir(env string, name string) string {
+	if dir := os.Getenv(env); dir != "" {
+		return dir
+	}
+
+	config, err := os.UserConfigDir()
+
+	if err != nil {
+		config = os.TempDir()
+	}
+
+	return filepath.Join(config, "chirpy", name)
+}
+
+// newMailer sends through SMTP when MAIL_SMTP_HOST is set and writes to an outbox directory otherwise
+func newMailer() (mail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+
+	if from == "" {
+		from = "Chirpy <no-reply@chirpy.local>"
+	}
+
+	if host := os.Getenv("MAIL_SMTP_HOST"); host != "" {
+		return mail.NewSMTP(host, envInt("MAIL_SMTP_PORT", 587), os.Getenv("MAIL_SMTP_USER"), os.Getenv("MAIL_SMTP_PASSWORD"), from), nil
+	}
+
+	dir := dataDir("MAIL_OUTBOX", "outbox")
+	log.Printf("Kein SMTP-Server konfiguriert, E-Mails landen in %s", dir)
+
+	return mail.NewOutbox(dir, from)
+}
+
+// sendMail delivers in the background so response times don't tell whether an email went out
+func (cfg *apiConfig) sendMail(msg mail.Message) {
+	go func() {
+		err := cfg.mailer.Send(msg)
+
+		if err != nil {
+			log.Printf("E-Mail an %s konnte nicht gesendet werden: %v", msg.To, err)
+		}
+	}()
+}
+
+// bootstrapAdmin creates the first admin. An account that doesn't exist yet
+// is created with the password from CHIRPY_ADMIN_PASSWORD or from stdin.
+func bootstrapAdmin(email string, policy passwords.Policy) {
+	db, err := database.NewDB("database.json")
+
+	if err != nil {
+		log.Fatalf("DB konnte nicht geöffnet werden: %v", err)
+	}
+
+	password := os.Getenv("CHIRPY_ADMIN_PASSWORD")
+
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Passwort (nur für neue Accounts): ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	user, err := db.BootstrapAdmin(email, password, policy)
+
+	if err != nil {
+		log.Fatalf("Admin konnte nicht angelegt werden: %v", err)
+	}
+
+	log.Printf("%s (ID %d) ist jetzt Admin", user.Email, user.ID)
+}
+
+// startKeyRotation replaces the JWT signing key once it is older than every
+func (cfg *apiConfig) startKeyRotation(every time.Duration, interval time.Duration) {
+	runEvery(interval, func() {
+		err := cfg.keys.Reload()
+
+		if err != nil {
+			log.Printf("Signaturschlüssel konnten nicht geladen werden: %v", err)
+			return
+		}
+
+		kid, err := cfg.keys.RotateIfDue(time.Now().UTC(), every)
+
+		if err != nil {
+			log.Printf("Signaturschlüssel konnte nicht rotiert werden: %v", err)
+			return
+		}
+
+		if kid != "" {
+			log.Printf("Neuer Signaturschlüssel %s", kid)
+		}
+	})
+}
+
+// lockedOut answers with 429 and a Retry-After header if err is a login lockout
+func lockedOut(w http.ResponseWriter, err error) bool {
+	var locked *database.LockedError
+
+	if !errors.As(err, &locked) {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+	respondWithError(w, 429, "Too many failed logins, try again later")
+
+	return true
+}
+
+// rejectedPassword answers with every rule the password broke if err is a
+// policy rejection
+func rejectedPassword(w http.ResponseWriter, err error) bool {
+	var invalid *passwords.ValidationError
+
+	if !errors.As(err, &invalid) {
+		return false
+	}
+
+	respondWithJSON(w, 400, returnPasswordError{
+		Error:      "Password rejected by policy",
+		Violations: invalid.Violations,
+	})
+
+	return true
+}
+
+func (cfg *apiConfig) applyFilter(w http.ResponseWriter, body string) (filter.Result, bool) {
+	result := cfg.filter.Apply(body)
+
+	if result.Rejected {
+		respondWithJSON(w, 400, returnFilterError{
+			Error:   "Chirp rejected by content filter",
+			Matches: result.Matches,
+		})
+		return result, false
+	}
+
+	return result, true
+}
+
+func main() {
+
+	dbg := flag.Bool("debug", false, "Enable debug mode")
+	createAdmin := flag.String("create-admin", "", "Make the account with this email an admin and exit")
+	flag.Parse()
+
+	if *dbg {
+		log.Print("Debugging enabled")
+		e := os.Remove("database.json")
+		if e != nil {
+			log.Print(e)
+		}
+	}
+
+	godotenv.Load()
+
+	passwordPolicyPath := os.Getenv("PASSWORD_POLICY")
+
+	if passwordPolicyPath == "" {
+		passwordPolicyPath = "password_policy.json"
+	}
+
+	passwordPolicy, err := passwords.LoadPolicy(passwordPolicyPath)
+
+	if err != nil {
+		log.Fatalf("Passwort-Richtlinie konnte nicht geladen werden: %v", err)
+	}
+
+	if *createAdmin != "" {
+		bootstrapAdmin(*createAdmin, passwordPolicy)
+		return
+	}
+
+	polkaSecret := os.Getenv("POLKA_SECRET")
+
+	filterPath := os.Getenv("FILTER_CONFIG")
+
+	if filterPath == "" {
+		filterPath = "filter.json"
+	}
+
+	contentFilter, err := filter.Load(filterPath)
+
+	if err != nil {
+		log.Fatalf("Filter-Konfiguration konnte nicht geladen werden: %v", err)
+	}
+
+	contentFilter.Watch(filterPath, filterWatchInterval)
+
+	policyPath := os.Getenv("TOKEN_POLICY")
+
+	if policyPath == "" {
+		policyPath = "token_policy.json"
+	}
+
+	tokenPolicy, err := auth.LoadPolicy(policyPath)
+
+	if err != nil {
+		log.Fatalf("Token-Richtlinie konnte nicht geladen werden: %v", err)
+	}
+
+	// tokens signed by a replaced key stay valid until they expire
+	keys, err := auth.LoadKeyring(dataDir("JWT_KEYS_DIR", "jwt-keys"), tokenPolicy.MaxAccess())
+
+	if err != nil {
+		log.Fatalf("Signaturschlüssel konnten nicht geladen werden: %v", err)
+	}
+
+	mailer, err := newMailer()
+
+	if err != nil {
+		log.Fatalf("Mailer konnte nicht erstellt werden: %v", err)
+	}
+
+	apiCfg := &apiConfig{
+		keys:                  keys,
+		mailer:                mailer,
+		tokenPolicy:           tokenPolicy,
+		passwordPolicy:        passwordPolicy,
+		filter:                contentFilter,
+		search:                search.NewIndex(),
+		users:                 search.NewUserIndex(),
+		maxChirpLength:        envInt("CHIRP_MAX_LENGTH", defaultMaxChirpLength),
+		maxChirpLengthPremium: envInt("CHIRP_MAX_LENGTH_PREMIUM", defaultMaxChirpLengthPremium),
+	}
+	err = apiCfg.buildSearchIndex("database.json")
+
+	if err != nil {
+		log.Fatalf("Suchindex konnte nicht aufgebaut werden: %v", err)
+	}
+
+	err = apiCfg.buildUserIndex("database.json")
+
+	if err != nil {
+		log.Fatalf("Benutzerindex konnte nicht aufgebaut werden: %v", err)
+	}
+
+	apiCfg.startScheduler("database.json", schedulerInterval)
+	apiCfg.startSweeper("database.json", sweeperInterval)
+	apiCfg.startKeyRotation(envDuration("JWT_KEY_ROTATION", defaultKeyRotation), keyCheckInterval)
+
+	mux := http.NewServeMux()
+
+	fileServerHandler := http.FileServer(http.Dir("."))
+
+	mux.Handle("/app/", apiCfg.middlewareMetricsInc(
+		http.StripPrefix("/app", fileServerHandler),
+	))
+
+	mux.Handle("/assets", http.FileServer(http.Dir("./assets")))
+
+	// public keys for services that verify our access tokens
+	mux.HandleFunc("GET /.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", jwksCacheControl)
+		respondWithJSON(w, 200, apiCfg.keys.JWKS())
+	})
+
+	mediaStore, err := media.NewStore("./media", "/media/")
+
+	if err != nil {
+		log.Fatalf("Media-Verzeichnis konnte nicht erstellt werden: %v", err)
+	}
+
+	// media files are named after their content hash and never change
+	mux.Handle("GET /media/", http.StripPrefix("/media/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", mediaCacheControl)
+		http.FileServer(http.Dir("./media")).ServeHTTP(w, r)
+	})))
+
+	mux.HandleFunc("GET /api/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("GET /api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Hits: " + strconv.Itoa(apiCfg.fileserverHits)))
+	})
+
+	mux.HandleFunc("/api/reset", apiCfg.middlewareRole(auth.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		apiCfg.fileserverHits = 0
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	mux.HandleFunc("GET /admin/metrics", apiCfg.middlewareRole(auth.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		html := fmt.Sprintf("<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p></body></html>", apiCfg.fileserverHits)
+		w.Write([]byte(html))
+	}))
+
+	mux.HandleFunc("PUT /api/admin/users/{id}/role", apiCfg.middlewareRole(auth.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		// keeps the last admin from locking everyone out
+		if id == userIDFromContext(r.Context()) {
+			respondWithError(w, 400, "You can't change your own role")
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err = decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		user, err := db.SetRole(id, params.Role)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Ändern der Rolle: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, user)
+	}))
+
+	mux.HandleFunc("GET /api/moderation/chirps", apiCfg.middlewareRole(auth.RoleModerator, func(w http.ResponseWriter, r *http.Request) {
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		limit, offset := pageParams(r)
+		chirps, err := db.GetFlaggedChirps(limit, offset)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Laden der Chirps: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, chirps)
+	}))
+
+	mux.HandleFunc("POST /api/moderation/chirps/{id}/approve", apiCfg.middlewareRole(auth.RoleModerator, func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		chirp, err := db.ApproveChirp(id)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Freigeben des Chirps: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, chirp)
+	}))
+
+	mux.HandleFunc("DELETE /api/moderation/chirps/{id}", apiCfg.middlewareRole(auth.RoleModerator, func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		success, err := db.RemoveChirp(id)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			apiCfg.search.Remove(id)
+			log.Printf("Chirp %d von Moderator %d entfernt", id, userIDFromContext(r.Context()))
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	mux.HandleFunc("GET /api/chirps", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, func(w http.ResponseWriter, r *http.Request) {
+		s := r.URL.Query().Get("author_id")
+		sort := r.URL.Query().Get("sort")
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		hideSensitive := r.URL.Query().Get("hide_sensitive") == "true"
+
+		chirps, err := db.GetChirps(s, sort, hideSensitive, userIDFromContext(r.Context()))
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Abrufen der Chirps: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, chirps)
+
+	}))
+
+	mux.HandleFunc("GET /api/chirps/{id}", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, func(w http.ResponseWriter, r *http.Request) {
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		// the viewer only decides whether poll results are shown
+		viewer := userIDFromContext(r.Context())
+		chirp, err := db.GetChirp(r.PathValue("id"), viewer)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Abrufen der Chirps: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, chirp)
+
+	}))
+
+	mux.HandleFunc("POST /api/chirps", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		user, err := db.GetUser(userID)
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		if !requireVerifiedEmail(w, user) {
+			return
+		}
+
+		if !apiCfg.checkLength(w, params.Body, user.Premium) {
+			return
+		}
+
+		if params.ExpiresIn < 0 {
+			respondWithError(w, 400, "expires_in must not be negative")
+			return
+		}
+
+		filtered, ok := apiCfg.applyFilter(w, params.Body)
+
+		if !ok {
+			return
+		}
+
+		// the content warning and poll options are shown with the chirp, so
+		// they go through the same rules as the body
+		warning, ok := apiCfg.applyFilter(w, strings.TrimSpace(params.ContentWarning))
+
+		if !ok {
+			return
+		}
+
+		body := filtered.Text
+		opts := database.ChirpOptions{
+			ExpiresIn:      time.Duration(params.ExpiresIn) * time.Second,
+			ContentWarning: warning.Text,
+			Sensitive:      params.Sensitive,
+			Flagged:        filtered.Flagged || warning.Flagged,
+			ReplyTo:        params.ReplyTo,
+		}
+
+		if params.Poll != nil {
+			for i, option := range params.Poll.Options {
+				filteredOption, ok := apiCfg.applyFilter(w, option)
+
+				if !ok {
+					return
+				}
+
+				params.Poll.Options[i] = filteredOption.Text
+				opts.Flagged = opts.Flagged || filteredOption.Flagged
+			}
+
+			opts.Poll, err = database.NewPoll(params.Poll.Options, params.Poll.ClosesAt)
+
+			if err != nil {
+				respondWithError(w, 400, "Ungültige Umfrage: "+err.Error())
+				return
+			}
+		}
+
+		if params.PublishAt != nil && params.PublishAt.After(time.Now()) {
+			scheduled, err := db.ScheduleChirp(body, userID, *params.PublishAt, opts)
+
+			if err != nil {
+				respondWithError(w, 400, "Fehler beim Planen des Chirps: "+err.Error())
+				return
+			}
+
+			respondWithJSON(w, 202, scheduled)
+			return
+		}
+
+		chirp, err := db.CreateChirp(body, userID, opts)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen des Chrip: "+err.Error())
+			return
+		}
+
+		apiCfg.search.Add(searchDocument(chirp))
+
+		respondWithJSON(w, 201, chirp)
+
+	}))
+
+	mux.HandleFunc("POST /api/users", func(w http.ResponseWriter, r *http.Request) {
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		log.Print(params.Email)
+		user, err := db.CreateUser(params.Email, params.Password, apiCfg.passwordPolicy)
+
+		if rejectedPassword(w, err) {
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen des User: "+err.Error())
+			return
+		}
+
+		err = apiCfg.sendVerification(db, user.ID, user.Email)
+
+		if err != nil {
+			log.Printf("Bestätigungs-E-Mail konnte nicht erstellt werden: %v", err)
+		}
+
+		respondWithJSON(w, 201, user)
+
+	})
+
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		if params.ExpiresInSeconds < 0 {
+			respondWithError(w, 400, "expires_in_seconds must not be negative")
+			return
+		}
+
+		client := clientInfo(r)
+		client.Type = apiCfg.tokenPolicy.ClientType(params.ClientType)
+		expiresIn := time.Duration(params.ExpiresInSeconds) * time.Second
+
+		user, err := db.Login(params.Email, params.Password, apiCfg.keys, apiCfg.tokenPolicy, client, expiresIn, time.Now().UTC())
+
+		if lockedOut(w, err) {
+			return
+		}
+
+		if errors.Is(err, database.ErrInvalidCredentials) {
+			respondWithError(w, 401, "Invalid email or password")
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 500, "Fehler beim Login: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, user)
+
+	})
+
+	// second login step for accounts with two-factor authentication
+	mux.HandleFunc("POST /api/login/mfa", func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		user, err := db.CompleteMFALogin(params.MFAToken, params.Code, apiCfg.keys, apiCfg.tokenPolicy, time.Now().UTC())
+
+		if lockedOut(w, err) {
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, user)
+	})
+
+	mux.HandleFunc("POST /api/mfa/totp", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		secret, user, err := db.EnrollTOTP(userID, time.Now().UTC())
+
+		if errors.Is(err, database.ErrMFAEnabled) {
+			respondWithError(w, 409, err.Error())
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Einrichten von TOTP: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, returnTOTP{
+			Secret:          secret,
+			ProvisioningURI: totp.ProvisioningURI(secret, totpIssuer, user.Email),
+		})
+	}))
+
+	mux.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		codes, err := db.ConfirmTOTP(userID, params.Code, time.Now().UTC())
+
+		if errors.Is(err, database.ErrMFAEnabled) {
+			respondWithError(w, 409, err.Error())
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Bestätigen von TOTP: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, returnRecoveryCodes{RecoveryCodes: codes})
+	}))
+
+	mux.HandleFunc("DELETE /api/mfa/totp", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		err = db.DisableTOTP(userID, params.Code, time.Now().UTC())
+
+		if errors.Is(err, database.ErrInvalidCode) {
+			respondWithError(w, 403, err.Error())
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Deaktivieren von TOTP: "+err.Error())
+			return
+		}
+
+		w.WriteHeader(204)
+	}))
+
+	mux.HandleFunc("PUT /api/users", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		user, err := db.UpdateUser(params.Email, params.Password, userID, apiCfg.passwordPolicy)
+
+		if rejectedPassword(w, err) {
+			return
+		}
+
+		if errors.Is(err, database.ErrInvalidEmail) {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+
+		if errors.Is(err, database.ErrEmailTaken) {
+			respondWithError(w, 409, err.Error())
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 401, "Fehler beim Erstellen des User: "+err.Error())
+			return
+		}
+
+		// the new address has to be confirmed before it replaces the old one
+		if user.PendingEmail != "" {
+			err = apiCfg.sendVerification(db, user.ID, user.PendingEmail)
+
+			if err != nil {
+				log.Printf("Bestätigungs-E-Mail konnte nicht erstellt werden: %v", err)
+			}
+		}
+
+		respondWithJSON(w, 200, user)
+
+	}))
+
+	mux.HandleFunc("POST /api/refresh", func(w http.ResponseWriter, r *http.Request) {
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != io.EOF {
+			w.WriteHeader(401)
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		// refresh tokens are opaque strings, not JWTs
+		tokenString, err := auth.BearerToken(r.Header)
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		newToken, refreshToken, err := db.RefreshToken(tokenString, apiCfg.keys, apiCfg.tokenPolicy, clientInfo(r))
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, returnToken{Token: newToken, RefreshToken: refreshToken})
+	})
+
+	mux.HandleFunc("POST /api/users/verify", func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		user, err := db.VerifyEmail(params.Token)
+
+		if errors.Is(err, database.ErrInvalidVerification) {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+
+		if errors.Is(err, database.ErrEmailTaken) {
+			respondWithError(w, 409, err.Error())
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 500, "Fehler beim Bestätigen der E-Mail: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, user)
+	})
+
+	// sends a new token for the pending address, or the current one while it is unverified
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		user, err := db.GetUser(userID)
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		email := user.PendingEmail
+
+		if email == "" && !user.EmailVerified {
+			email = user.Email
+		}
+
+		if email == "" {
+			respondWithError(w, 409, "Email address is already verified")
+			return
+		}
+
+		err = apiCfg.sendVerification(db, user.ID, email)
+
+		if err != nil {
+			respondWithError(w, 500, "Fehler beim Senden der Bestätigung: "+err.Error())
+			return
+		}
+
+		w.WriteHeader(202)
+	}))
+
+	// always 202 so the endpoint can't be used to find out which emails have an account
+	mux.HandleFunc("POST /api/password-reset", func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		token, user, err := db.CreatePasswordReset(params.Email, passwordResetLifetime)
+
+		if err == nil {
+			apiCfg.sendMail(mail.Message{
+				To:      user.Email,
+				Subject: "Reset your Chirpy password",
+				Body: "Someone asked to reset the password of your Chirpy account.\n\n" +
+					"Your reset token is:\n\n    " + token + "\n\n" +
+					"It expires in " + passwordResetLifetime.String() + " and can only be used once. " +
+					"If this wasn't you, you can ignore this email.\n",
+			})
+		} else if !errors.Is(err, database.ErrUnknownEmail) {
+			log.Printf("Reset-Token konnte nicht erstellt werden: %v", err)
+		}
+
+		w.WriteHeader(202)
+	})
+
+	mux.HandleFunc("POST /api/password-reset/confirm", func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		if params.Token == "" || params.Password == "" {
+			respondWithError(w, 400, "token and password are required")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		_, err = db.ResetPassword(params.Token, params.Password, apiCfg.passwordPolicy)
+
+		if errors.Is(err, database.ErrInvalidReset) {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+
+		if rejectedPassword(w, err) {
+			return
+		}
+
+		if err != nil {
+			respondWithError(w, 500, "Fehler beim Zurücksetzen des Passworts: "+err.Error())
+			return
+		}
+
+		w.WriteHeader(204)
+	})
+
+	mux.HandleFunc("POST /api/revoke", func(w http.ResponseWriter, r *http.Request) {
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		tokenString, err := auth.BearerToken(r.Header)
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+
+		success, err := db.RevokeToken(tokenString)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(401)
+		}
+	})
+
+	mux.HandleFunc("GET /api/sessions", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		sessions, err := db.GetSessions(userID, sessionIDFromContext(r.Context()))
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Laden der Sessions: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, sessions)
+	}))
+
+	mux.HandleFunc("DELETE /api/sessions/{id}", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		success, err := db.RevokeSession(userID, r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	// log out everywhere, including the session of this request
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		_, err = db.RevokeAllSessions(userID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		w.WriteHeader(204)
+	}))
+
+	// API keys are managed from a session only, a key can't mint more keys
+	mux.HandleFunc("POST /api/keys", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		key, err := db.CreateAPIKey(userID, params.Name, params.Scopes)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen des API-Keys: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 201, key)
+	}))
+
+	mux.HandleFunc("GET /api/keys", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		keys, err := db.GetAPIKeys(userID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Laden der API-Keys: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, keys)
+	}))
+
+	mux.HandleFunc("DELETE /api/keys/{id}", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		success, err := db.DeleteAPIKey(userID, id)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	mux.HandleFunc("DELETE /api/chirps/{ID}", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		chirpID, err := strconv.Atoi(r.PathValue("ID"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		success, err := db.DeleteChirp(userID, chirpID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			apiCfg.search.Remove(chirpID)
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(403)
+		}
+
+	}))
+
+	mux.HandleFunc("POST /api/polka/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		apiKey, err := auth.APIKey(r.Header)
+
+		if err != nil || subtle.ConstantTimeCompare([]byte(apiKey), []byte(polkaSecret)) != 1 {
+			w.WriteHeader(401)
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err = decoder.Decode(&params)
+
+		if err != nil {
+			w.WriteHeader(401)
+			return
+		}
+
+		if params.Event != "user.upgraded" {
+			w.WriteHeader(204)
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			return
+		}
+
+		succ, err := db.UpdatePremium(params.Data.User)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler "+err.Error())
+			return
+		}
+
+		if succ {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+
+	})
+
+	mux.HandleFunc("POST /api/bookmarks", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		err = db.AddBookmark(userID, params.ChirpID)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Speichern des Lesezeichens: "+err.Error())
+			return
+		}
+
+		w.WriteHeader(204)
+	}))
+
+	mux.HandleFunc("GET /api/bookmarks", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		limit, offset := pageParams(r)
+		hideSensitive := r.URL.Query().Get("hide_sensitive") == "true"
+		chirps, err := db.GetBookmarks(userID, limit, offset, hideSensitive)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Abrufen der Lesezeichen: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, chirps)
+	}))
+
+	mux.HandleFunc("DELETE /api/bookmarks/{chirpID}", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		success, err := db.RemoveBookmark(userID, chirpID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	mux.HandleFunc("POST /api/lists", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		list, err := db.CreateList(userID, params.Name)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der Liste: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 201, list)
+	}))
+
+	mux.HandleFunc("GET /api/lists", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		lists, err := db.GetLists(userID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Abrufen der Listen: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, lists)
+	}))
+
+	mux.HandleFunc("GET /api/lists/{id}", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		listID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		list, err := db.GetList(userID, listID)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Abrufen der Liste: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, list)
+	}))
+
+	mux.HandleFunc("DELETE /api/lists/{id}", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		listID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		success, err := db.DeleteList(userID, listID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	mux.HandleFunc("POST /api/lists/{id}/members", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		listID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err = decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		list, err := db.AddListMember(userID, listID, params.UserID)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Aktualisieren der Liste: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, list)
+	}))
+
+	mux.HandleFunc("DELETE /api/lists/{id}/members/{userID}", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		listID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		memberID, err := strconv.Atoi(r.PathValue("userID"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		success, err := db.RemoveListMember(userID, listID, memberID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	mux.HandleFunc("GET /api/lists/{id}/chirps", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		listID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		limit, offset := pageParams(r)
+		hideSensitive := r.URL.Query().Get("hide_sensitive") == "true"
+		chirps, err := db.GetListTimeline(userID, listID, limit, offset, hideSensitive)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Abrufen der Chirps: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, chirps)
+	}))
+
+	mux.HandleFunc("POST /api/chirps/{id}/pin", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		chirpID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		user, err := db.PinChirp(userID, chirpID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Anheften des Chirps: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, user)
+	}))
+
+	mux.HandleFunc("DELETE /api/chirps/{id}/pin", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		chirpID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		success, err := db.UnpinChirp(userID, chirpID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	mux.HandleFunc("GET /api/scheduled", apiCfg.middlewareScope(auth.ScopeChirpsRead, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		scheduled, err := db.GetScheduledChirps(userID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Abrufen der geplanten Chirps: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, scheduled)
+	}))
+
+	mux.HandleFunc("PUT /api/scheduled/{id}", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err = decoder.Decode(&params)
+
+		if err != nil || params.PublishAt == nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		scheduled, err := db.RescheduleChirp(userID, id, *params.PublishAt)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Verschieben des Chirps: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, scheduled)
+	}))
+
+	mux.HandleFunc("DELETE /api/scheduled/{id}", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		success, err := db.CancelScheduledChirp(userID, id)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	mux.HandleFunc("POST /api/drafts", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err := decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		if len(params.Body) > maxDraftLength {
+			respondWithError(w, 400, "Draft is too long")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		draft, err := db.CreateDraft(userID, params.Body)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen des Entwurfs: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 201, draft)
+	}))
+
+	mux.HandleFunc("GET /api/drafts", apiCfg.middlewareScope(auth.ScopeChirpsRead, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		drafts, err := db.GetDrafts(userID)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Abrufen der Entwürfe: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, drafts)
+	}))
+
+	mux.HandleFunc("GET /api/drafts/{id}", apiCfg.middlewareScope(auth.ScopeChirpsRead, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		draft, err := db.GetDraft(userID, id)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Abrufen des Entwurfs: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, draft)
+	}))
+
+	mux.HandleFunc("PUT /api/drafts/{id}", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+
+		err = decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+
+		if len(params.Body) > maxDraftLength {
+			respondWithError(w, 400, "Draft is too long")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		draft, err := db.UpdateDraft(userID, id, params.Body)
+
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Aktualisieren des Entwurfs: "+err.Error())
+			return
+		}
+
+		respondWithJSON(w, 200, draft)
+	}))
+
+	mux.HandleFunc("DELETE /api/drafts/{id}", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		success, err := db.DeleteDraft(userID, id)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler "+err.Error())
+			return
+		}
+
+		if success {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+
+	mux.HandleFunc("POST /api/drafts/{id}/publish", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-	msgSlice := strings.Split(msg, " ")
+		id, err := strconv.Atoi(r.PathValue("id"))
 
-	for i := 0; i < len(msgSlice); i++ {
-		if strings.ToLower(msgSlice[i]) == "kerfuffle" ||
-			strings.ToLower(msgSlice[i]) == "sharbert" ||
-			strings.ToLower(msgSlice[i]) == "fornax" {
-			msgSlice[i] = "****"
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
 		}
-	}
 
-	msgCheck := strings.Join(msgSlice, " ")
+		db, err := database.NewDB("database.json")
 
-	return msgCheck
-}
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
 
-func main() {
+		draft, err := db.GetDraft(userID, id)
 
-	dbg := flag.Bool("debug", false, "Enable debug mode")
-	flag.Parse()
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Abrufen des Entwurfs: "+err.Error())
+			return
+		}
 
-	if *dbg {
-		log.Print("Debugging enabled")
-		e := os.Remove("database.json")
-		if e != nil {
-			log.Print(e)
+		user, err := db.GetUser(userID)
+
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			return
 		}
-	}
 
-	godotenv.Load()
-	jwtSecret := os.Getenv("JWT_SECRET")
-	polkaSecret := os.Getenv("POLKA_SECRET")
+		if !requireVerifiedEmail(w, user) {
+			return
+		}
 
-	apiCfg := &apiConfig{jwt: jwtSecret}
-	mux := http.NewServeMux()
+		if !apiCfg.checkLength(w, draft.Body, user.Premium) {
+			return
+		}
 
-	fileServerHandler := http.FileServer(http.Dir("."))
+		filtered, ok := apiCfg.applyFilter(w, draft.Body)
 
-	mux.Handle("/app/", apiCfg.middlewareMetricsInc(
-		http.StripPrefix("/app", fileServerHandler),
-	))
+		if !ok {
+			return
+		}
 
-	mux.Handle("/assets", http.FileServer(http.Dir("./assets")))
+		chirp, err := db.PublishDraft(userID, id, filtered.Text, database.ChirpOptions{Flagged: filtered.Flagged})
 
-	mux.HandleFunc("GET /api/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Veröffentlichen des Entwurfs: "+err.Error())
+			return
+		}
 
-	mux.HandleFunc("GET /api/metrics", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Hits: " + strconv.Itoa(apiCfg.fileserverHits)))
-	})
+		apiCfg.search.Add(searchDocument(chirp))
 
-	mux.HandleFunc("/api/reset", func(w http.ResponseWriter, r *http.Request) {
-		apiCfg.fileserverHits = 0
-		w.WriteHeader(http.StatusOK)
-	})
+		respondWithJSON(w, 201, chirp)
+	}))
 
-	mux.HandleFunc("GET /admin/metrics", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		html := fmt.Sprintf("<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p></body></html>", apiCfg.fileserverHits)
-		w.Write([]byte(html))
-	})
+	mux.HandleFunc("POST /api/chirps/{id}/vote", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-	mux.HandleFunc("GET /api/chirps", func(w http.ResponseWriter, r *http.Request) {
-		s := r.URL.Query().Get("author_id")
-		sort := r.URL.Query().Get("sort")
+		chirpID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
 
+		err = decoder.Decode(&params)
+
+		if err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
 
 		db, err := database.NewDB("database.json")
 
@@ -167,17 +2525,25 @@ func main() {
 			return
 		}
 
-		chirps, err := db.GetChirps(s, sort)
+		chirp, err := db.Vote(chirpID, userID, params.Option)
+
 		if err != nil {
-			respondWithError(w, 400, "Fehler beim Abrufen der Chirps: "+err.Error())
+			respondWithError(w, 400, "Fehler beim Abstimmen: "+err.Error())
 			return
 		}
 
-		respondWithJSON(w, 200, chirps)
+		respondWithJSON(w, 200, chirp)
+	}))
 
-	})
+	mux.HandleFunc("POST /api/chirps/{id}/media", apiCfg.middlewareScope(auth.ScopeChirpsWrite, func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-	mux.HandleFunc("GET /api/chirps/{id}", func(w http.ResponseWriter, r *http.Request) {
+		chirpID, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
+		}
 
 		db, err := database.NewDB("database.json")
 
@@ -186,64 +2552,98 @@ func main() {
 			return
 		}
 
-		chirp, err := db.GetChirp(r.PathValue("id"))
+		user, err := db.GetUser(userID)
 
 		if err != nil {
-			respondWithError(w, 404, "Fehler beim Abrufen der Chirps: "+err.Error())
+			respondWithError(w, 401, "Unauthorized: "+err.Error())
 			return
 		}
 
-		respondWithJSON(w, 200, chirp)
+		limit := int64(maxUploadSize)
 
-	})
+		if user.Premium {
+			limit = maxUploadSizePremium
+		}
 
-	mux.HandleFunc("POST /api/chirps", func(w http.ResponseWriter, r *http.Request) {
+		// refuse before the upload is read, files are stored before they're attached
+		err = db.CanAttachMedia(userID, chirpID)
 
-		decoder := json.NewDecoder(r.Body)
-		params := parameters{}
+		if err != nil {
+			respondWithError(w, 404, "Fehler beim Anhängen der Datei: "+err.Error())
+			return
+		}
 
-		err := decoder.Decode(&params)
+		// leave some room for the multipart framing around the file
+		r.Body = http.MaxBytesReader(w, r.Body, limit+1<<20)
+
+		err = r.ParseMultipartForm(limit)
 
 		if err != nil {
-			respondWithError(w, 400, "Something went wrong")
+			respondWithError(w, 413, "Upload zu groß oder ungültig: "+err.Error())
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+
+		if err != nil {
+			respondWithError(w, 400, "Datei fehlt: "+err.Error())
 			return
 		}
 
-		if len(params.Body) > 140 {
-			respondWithError(w, 400, "Chirp is too long")
+		defer file.Close()
+
+		if header.Size > limit {
+			respondWithError(w, 413, "Upload zu groß")
 			return
 		}
 
-		tokenEx := r.Header.Get("Authorization")
-		tokenString := strings.Split(tokenEx, " ")[1]
+		data, err := io.ReadAll(file)
 
-		db, err := database.NewDB("database.json")
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Lesen der Datei: "+err.Error())
+			return
+		}
+
+		attachment, err := mediaStore.Save(data)
+
+		if errors.Is(err, media.ErrUnsupportedType) {
+			respondWithError(w, 415, "Nicht unterstützter Dateityp")
+			return
+		}
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			respondWithError(w, 400, "Fehler beim Verarbeiten des Bildes: "+err.Error())
 			return
 		}
 
-		body := checkWords(params.Body)
-		chirp, err := db.CreateChirp(body, tokenString)
+		chirp, err := db.AttachMedia(userID, chirpID, database.Media{
+			Hash:         attachment.Hash,
+			MimeType:     attachment.MimeType,
+			URL:          attachment.URL,
+			ThumbnailURL: attachment.ThumbnailURL,
+			Width:        attachment.Width,
+			Height:       attachment.Height,
+		})
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler beim Erstellen des Chrip: "+err.Error())
+			respondWithError(w, 404, "Fehler beim Anhängen der Datei: "+err.Error())
 			return
 		}
 
-		respondWithJSON(w, 201, chirp)
+		apiCfg.search.Add(searchDocument(chirp))
 
-	})
+		respondWithJSON(w, 201, chirp)
+	}))
 
-	mux.HandleFunc("POST /api/users", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("PUT /api/users/preferences", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
 		decoder := json.NewDecoder(r.Body)
 		params := parameters{}
 
 		err := decoder.Decode(&params)
 
-		if err != nil {
+		if err != nil || params.AutoExpand == nil {
 			respondWithError(w, 400, "Something went wrong")
 			return
 		}
@@ -255,19 +2655,57 @@ func main() {
 			return
 		}
 
-		log.Print(params.Email)
-		user, err := db.CreateUser(params.Email, params.Password)
+		user, err := db.UpdatePreferences(userID, *params.AutoExpand)
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler beim Erstellen des User: "+err.Error())
+			respondWithError(w, 404, "Fehler beim Speichern der Einstellungen: "+err.Error())
 			return
 		}
 
-		respondWithJSON(w, 201, user)
+		respondWithJSON(w, 200, user)
+	}))
 
-	})
+	mux.HandleFunc("GET /api/search", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
 
-	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimSpace(q) == "" {
+			respondWithError(w, 400, "q is required")
+			return
+		}
+
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		visible, err := db.VisibleChirpIDs()
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Abrufen der Chirps: "+err.Error())
+			return
+		}
+
+		// hidden chirps are dropped before paging, otherwise pages come back
+		// short and the total counts chirps nobody can see
+		limit, offset := pageParams(r)
+		ids, total := apiCfg.search.Search(search.Parse(q), func(id int) bool { return visible[id] }, limit, offset)
+		viewer := userIDFromContext(r.Context())
+
+		chirps, err := db.GetChirpsByID(ids, viewer)
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Abrufen der Chirps: "+err.Error())
+			return
+		}
+
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		respondWithJSON(w, 200, chirps)
+	}))
+
+	mux.HandleFunc("PUT /api/users/profile", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
 		decoder := json.NewDecoder(r.Body)
 		params := parameters{}
@@ -286,26 +2724,24 @@ func main() {
 			return
 		}
 
-		user, err := db.Login(params.Email, params.Password, apiCfg.jwt)
+		user, err := db.UpdateProfile(userID, params.Username, params.DisplayName)
 
 		if err != nil {
-			respondWithError(w, 401, "Fehler beim Erstellen des User: "+err.Error())
+			respondWithError(w, 400, "Fehler beim Aktualisieren des Profils: "+err.Error())
 			return
 		}
 
+		apiCfg.users.Put(userResult(user))
 		respondWithJSON(w, 200, user)
+	}))
 
-	})
-
-	mux.HandleFunc("PUT /api/users", func(w http.ResponseWriter, r *http.Request) {
-
-		decoder := json.NewDecoder(r.Body)
-		params := parameters{}
+	mux.HandleFunc("POST /api/users/{id}/block", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-		err := decoder.Decode(&params)
+		id, err := strconv.Atoi(r.PathValue("id"))
 
 		if err != nil {
-			respondWithError(w, 400, "Something went wrong")
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
 			return
 		}
 
@@ -316,174 +2752,185 @@ func main() {
 			return
 		}
 
-		tokenEx := r.Header.Get("Authorization")
-		tokenString := strings.Split(tokenEx, " ")[1]
+		err = db.BlockUser(userID, id)
 
-		claim, err2 := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(apiCfg.jwt), nil
-		})
-
-		if err2 != nil {
-			respondWithError(w, 401, "Unauthorized: "+err2.Error())
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Blockieren: "+err.Error())
 			return
 		}
 
-		id, err4 := claim.Claims.GetSubject()
+		apiCfg.users.Block(userID, id)
+		w.WriteHeader(204)
+	}))
+
+	mux.HandleFunc("DELETE /api/users/{id}/block", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-		if err4 != nil {
-			respondWithError(w, 401, "Unauthorized: "+err4.Error())
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
 		}
 
-		idCast, _ := strconv.Atoi(id)
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
 
-		user, err := db.UpdateUser(params.Email, params.Password, idCast)
+		success, err := db.UnblockUser(userID, id)
 
 		if err != nil {
-			respondWithError(w, 401, "Fehler beim Erstellen des User: "+err.Error())
+			respondWithError(w, 400, "Fehler "+err.Error())
 			return
 		}
 
-		respondWithJSON(w, 200, user)
+		if success {
+			apiCfg.users.Unblock(userID, id)
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
 
-	})
+	mux.HandleFunc("GET /api/users/search", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-	mux.HandleFunc("POST /api/refresh", func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := pageParams(r)
+		respondWithJSON(w, 200, apiCfg.users.Search(r.URL.Query().Get("q"), userID, limit))
+	}))
 
-		decoder := json.NewDecoder(r.Body)
-		params := parameters{}
+	mux.HandleFunc("POST /api/chirps/{id}/like", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-		err := decoder.Decode(&params)
+		id, err := strconv.Atoi(r.PathValue("id"))
 
-		if err != io.EOF {
-			w.WriteHeader(401)
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
 			return
 		}
 
 		db, err := database.NewDB("database.json")
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
 			return
 		}
 
-		tokenEx := r.Header.Get("Authorization")
-		tokenString := strings.Split(tokenEx, " ")[1]
-
-		newToken, err := db.RefreshToken(tokenString, apiCfg.jwt)
+		chirp, err := db.LikeChirp(userID, id, true)
 
 		if err != nil {
-			respondWithError(w, 401, "Unauthorized: "+err.Error())
+			respondWithError(w, 400, "Fehler beim Liken: "+err.Error())
 			return
 		}
 
-		respondWithJSON(w, 200, returnToken{Token: newToken})
-	})
+		respondWithJSON(w, 200, chirp)
+	}))
 
-	mux.HandleFunc("POST /api/revoke", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("DELETE /api/chirps/{id}/like", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-		db, err := database.NewDB("database.json")
+		id, err := strconv.Atoi(r.PathValue("id"))
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
 			return
 		}
 
-		tokenEx := r.Header.Get("Authorization")
-		tokenString := strings.Split(tokenEx, " ")[1]
+		db, err := database.NewDB("database.json")
 
-		success, err := db.RevokeToken(tokenString)
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
+			return
+		}
+
+		chirp, err := db.LikeChirp(userID, id, false)
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler "+err.Error())
+			respondWithError(w, 400, "Fehler beim Liken: "+err.Error())
 			return
 		}
 
-		if success {
-			w.WriteHeader(204)
-		} else {
-			w.WriteHeader(401)
+		respondWithJSON(w, 200, chirp)
+	}))
+
+	mux.HandleFunc("POST /api/chirps/{id}/rechirp", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
+			return
 		}
-	})
 
-	mux.HandleFunc("DELETE /api/chirps/{ID}", func(w http.ResponseWriter, r *http.Request) {
 		db, err := database.NewDB("database.json")
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
 			return
 		}
 
-		tokenEx := r.Header.Get("Authorization")
-		tokenString := strings.Split(tokenEx, " ")[1]
-
-		success, err := db.DeleteChirp(tokenString)
+		chirp, err := db.Rechirp(userID, id, true)
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler "+err.Error())
+			respondWithError(w, 400, "Fehler beim Rechirpen: "+err.Error())
 			return
 		}
 
-		if success {
-			w.WriteHeader(204)
-		} else {
-			w.WriteHeader(403)
-		}
+		respondWithJSON(w, 200, chirp)
+	}))
 
-	})
+	mux.HandleFunc("DELETE /api/chirps/{id}/rechirp", apiCfg.middlewareAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := userIDFromContext(r.Context())
 
-	mux.HandleFunc("POST /api/polka/webhooks", func(w http.ResponseWriter, r *http.Request) {
-		tokenEx := r.Header.Get("Authorization")
+		id, err := strconv.Atoi(r.PathValue("id"))
 
-		if tokenEx == "" {
-			w.WriteHeader(401)
+		if err != nil {
+			respondWithError(w, 400, "Ungültige ID: "+err.Error())
 			return
 		}
 
-		tokenString := strings.Split(tokenEx, " ")[1]
-		
-		if tokenString != polkaSecret {
-			log.Print(tokenString)
-			log.Print(polkaSecret)
-			w.WriteHeader(401)
+		db, err := database.NewDB("database.json")
+
+		if err != nil {
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		params := parameters{}
-
-		err := decoder.Decode(&params)
+		chirp, err := db.Rechirp(userID, id, false)
 
 		if err != nil {
-			w.WriteHeader(401)
+			respondWithError(w, 400, "Fehler beim Rechirpen: "+err.Error())
 			return
 		}
 
-		if params.Event != "user.upgraded" {
-			w.WriteHeader(204)
-			return
-		} 
-			
+		respondWithJSON(w, 200, chirp)
+	}))
+
+	mux.HandleFunc("GET /api/explore", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, func(w http.ResponseWriter, r *http.Request) {
 		db, err := database.NewDB("database.json")
 
 		if err != nil {
-			respondWithError(w, 400, "Fehler beim Erstellen der DB-Verbindung: "+err.Error())
+			respondWithError(w, 400, "Fehler beim Erstellen der DB: "+err.Error())
 			return
 		}
 
-		succ, err := db.UpdatePremium(params.Data.User)
+		// explore is a public page, sensitive chirps only show up on request
+		hideSensitive := r.URL.Query().Get("hide_sensitive") != "false"
+
+		limit, offset := pageParams(r)
+		chirps, err := db.GetPopularChirps(limit, offset, hideSensitive, userIDFromContext(r.Context()))
 
 		if err != nil {
-			respondWithError(w, 404, "Fehler "+err.Error())
+			respondWithError(w, 400, "Fehler beim Abrufen der Chirps: "+err.Error())
 			return
 		}
 
-		if succ {
-			w.WriteHeader(204)
-		} else {
-			w.WriteHeader(404)
-		}
-
-	})
+		respondWithJSON(w, 200, chirps)
+	}))
 
 	server := &http.Server{
 		Addr:    "localhost:8080",
@@ -491,7 +2938,7 @@ func main() {
 	}
 
 	fmt.Printf("Server wird versucht zu starten... http://localhost:8080")
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 
 	if err != nil {
 		fmt.Printf("Server konnte nicht gestartet werden: %v", err)